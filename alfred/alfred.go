@@ -11,8 +11,20 @@ type Item struct {
 	Arg          string    `json:"arg,omitempty"`
 	Icon         Icon      `json:"icon,omitempty"`
 	Variables    Variables `json:"variables,omitempty"`
+	Quicklookurl string    `json:"quicklookurl,omitempty"`
+	Mods         Mods      `json:"mods,omitempty"`
 	Valid        bool      `json:"valid,omitempty"`
 	Save         bool      `json:"-"` // indicates whether this item should be saved in history
+	FromQuery    bool      `json:"-"` // indicates that the item is made of the query, it isn't saved when queries are hashed
+}
+
+// Mods maps modifier keys (cmd, alt, ctrl, shift, fn) to alternative actions.
+type Mods map[string]Mod
+
+type Mod struct {
+	Arg       string     `json:"arg"`
+	Subtitle  string     `json:"subtitle,omitempty"`
+	Variables *Variables `json:"variables,omitempty"`
 }
 
 type Icon struct {
@@ -29,6 +41,8 @@ type Variables struct {
 	Template        string `json:"template,omitempty"`
 	Arg             string `json:"arg,omitempty"`
 	HistItem        string `json:"hist_item,omitempty"`
+	Action          string `json:"action,omitempty"`
+	FromHistory     string `json:"from_history,omitempty"`
 	Query           string `json:"query"`
 }
 
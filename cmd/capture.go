@@ -4,6 +4,8 @@ Copyright © 2023 Peter Solodov <solodov@gmail.com>
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
@@ -14,6 +16,8 @@ import (
 	"strings"
 
 	"github.com/solodov/org-roam-alfred-items/alfred"
+	"github.com/solodov/org-roam-alfred-items/config"
+	"github.com/solodov/org-roam-alfred-items/emacs"
 	"github.com/spf13/cobra"
 )
 
@@ -25,52 +29,39 @@ var captureItemsCmd = &cobra.Command{
 	Use:   "items",
 	Short: "Perform org capture",
 	Args:  cobra.NoArgs,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		category, err := lookupCategory(captureCmdArgs.category)
+		if category == nil {
+			return err
+		}
 		result := alfred.Result{}
 		initVariables(&result.Variables)
 		browserState := result.Variables.DecodeBrowserState()
-		addItem := func(title, template string, valid bool) {
-			result.Items = append(result.Items, captureItem(title, template, valid))
-		}
-		if captureCmdArgs.category == "home" {
-			// if result.Variables.Meeting != "nil" {
-			// 	addItem(fmt.Sprintf("capture meeting notes for %q", result.Variables.Meeting), "m", true)
-			// }
-			addItem("capture note into inbox", "h", captureCmdArgs.query != "")
-			if result.Variables.ClockedInTask != "nil" {
-				addItem("capture note for the clocked-in task", "c", captureCmdArgs.query != "")
-			}
-			if browserState != nil {
-				addItem(fmt.Sprintf("capture %q into inbox", browserState), "bh", true)
+		for _, t := range category.CaptureTemplates {
+			title := t.Title
+			switch t.Context {
+			case "clocked_in":
+				if result.Variables.ClockedInTask == "nil" {
+					continue
+				}
+			case "browser":
+				if browserState == nil {
+					continue
+				}
+				title = strings.ReplaceAll(title, "{browser}", fmt.Sprintf("%q", browserState))
 			}
-		} else if captureCmdArgs.category == "goog" {
-			// if result.Variables.Meeting != "nil" {
-			// 	addItem(fmt.Sprintf("capture meeting notes for %q", result.Variables.Meeting), "e", true)
-			// }
-			addItem("capture note into inbox", "g", captureCmdArgs.query != "")
-			if result.Variables.ClockedInTask != "nil" {
-				addItem("capture note for the clocked-in task", "c", captureCmdArgs.query != "")
-			}
-			if browserState != nil {
-				addItem(fmt.Sprintf("capture %q into inbox", browserState), "bg", true)
-				addItem(fmt.Sprintf("capture %q for ads doc review", browserState), "bd", true)
-				addItem(fmt.Sprintf("capture %q for ads fact", browserState), "bf", true)
-				addItem(fmt.Sprintf("capture %q for career reading", browserState), "bc", true)
-			}
-			addItem("capture ads fact", "f", true)
-		} else {
-			log.Fatalf("unknown category: %v", captureCmdArgs.category)
+			result.Items = append(result.Items, captureItem(title, t, !t.NeedsQuery || captureCmdArgs.query != ""))
 		}
-		printJson(result)
+		return printJson(result)
 	},
 }
 
-func captureItem(title, template string, valid bool) (item alfred.Item) {
+func captureItem(title string, t config.CaptureTemplate, valid bool) (item alfred.Item) {
 	item.Title = title
 	item.Arg = captureCmdArgs.query
 	item.Valid = valid
-	item.Variables.Arg = template
-	if template == "e" {
+	item.Variables.Arg = t.Key
+	if t.Immediate() == t.Key {
 		item.Subtitle = "continue editing"
 	} else {
 		item.Subtitle = "finish immediately"
@@ -81,38 +72,33 @@ func captureItem(title, template string, valid bool) (item alfred.Item) {
 var captureActCmd = &cobra.Command{
 	Use:  "act",
 	Args: cobra.NoArgs,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		category := cfg.Category(captureCmdArgs.category)
+		if category == nil {
+			return fmt.Errorf("unknown category %q", captureCmdArgs.category)
+		}
 		variables := alfred.Variables{}
 		initVariables(&variables)
 		browserState := variables.DecodeBrowserState()
 
-		template := os.Getenv("arg")
-		if template == "ie" {
-			// e is for meetings, immediate finish (the i prefix) doesn't apply,
-			// always edit meeting notes
-			template = "e"
-		} else if template == "im" {
-			template = "m"
-		} else if strings.HasPrefix(template, "ib") {
-			// immediate finish for browser capture has its own series of templates,
-			// alfred just adds i for simplicity.
-			template = "y" + strings.TrimPrefix(template, "ib")
+		// Alfred prefixes the key with i for immediate finish.
+		t, immediate := category.CaptureTemplate(os.Getenv("arg"))
+		if t == nil {
+			return fmt.Errorf("category %v has no capture template %q", category.Name, os.Getenv("arg"))
+		}
+		template := t.Key
+		if immediate {
+			template = t.Immediate()
 		}
 
 		q := url.Values{}
 		q.Set("template", template)
 		if captureCmdArgs.query != "" {
-			switch template {
-			case "h", "ih", "g", "ig", "e", "m", "f":
-				q.Set("body", captureCmdArgs.query)
-			default:
-				// Immediate finish means add some empty lines.
-				q.Set("body", captureCmdArgs.query+"\n\n")
-			}
+			q.Set("body", captureCmdArgs.query+t.BodySuffix)
 		}
-		if template[0] == 'b' || template[0] == 'y' {
+		if t.Context == "browser" {
 			if browserState == nil {
-				log.Fatal("capture template requires browser state, but it's not provided")
+				return errors.New("capture template requires browser state, but it's not provided")
 			}
 			// These are browser capture templates, add URL and title.
 			q.Set("url", browserState.Url)
@@ -126,19 +112,26 @@ var captureActCmd = &cobra.Command{
 		log.Printf("query: %#v\n", captureCmdArgs.query)
 		log.Printf("url: %s\n", u.String())
 
-		if template[0] != 'i' && template[0] != 'y' {
+		ctx := context.Background()
+		if template == t.Key {
 			// This is not an immediate finish template, raise emacs frame so
 			// continuing to edit is nicer.
-			if err := exec.Command("emacsclient", "-e", "(select-frame-set-input-focus (selected-frame))").Run(); err != nil {
-				log.Fatal("setting frame focus failed: ", err)
+			if err := focusEmacsFrame(ctx); err != nil {
+				return fmt.Errorf("setting frame focus failed: %v", err)
 			}
 		}
-		if err := exec.Command("emacsclient", "-n", u.String()).Run(); err != nil {
-			log.Fatal("opening url failed: ", err)
+		if err := emacs.Default.Open(ctx, u.String()); err != nil {
+			return fmt.Errorf("opening url failed: %v", err)
 		}
+		return nil
 	},
 }
 
+func focusEmacsFrame(ctx context.Context) error {
+	_, err := emacs.Default.Eval(ctx, "(select-frame-set-input-focus (selected-frame))")
+	return err
+}
+
 func initVariables(variables *alfred.Variables) {
 	for _, varData := range []struct {
 		name   string
@@ -202,12 +195,18 @@ func fetchMeeting() (meeting string) {
 	return meeting
 }
 
+// fetchClockedInTask returns the title of the clocked-in task, "nil" when there is none.
 func fetchClockedInTask() (t string) {
 	t = "nil"
-	if out, err := exec.Command("emacsclient", "-e", "(org-clock-is-active)").Output(); err != nil {
+	out, err := emacs.Default.Eval(context.Background(), "(and (org-clocking-p) (substring-no-properties org-clock-current-task))")
+	if errors.Is(err, emacs.ErrNotRunning) {
+		log.Println("emacs is not running, assuming no clocked-in task")
+	} else if err != nil {
 		log.Println("calling emacsclient failed: ", err)
-	} else {
-		t = strings.Trim(string(out), "\n")
+	} else if task, err := emacs.ParseString(out); err != nil {
+		log.Println("unexpected clocked-in task: ", err)
+	} else if task != "" {
+		t = task
 	}
 	return t
 }
@@ -1,12 +1,19 @@
 package cmd
 
 import (
-	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/solodov/org-roam-alfred-items/alfred"
 	"github.com/solodov/org-roam-alfred-items/history"
+	"github.com/solodov/org-roam-alfred-items/match"
 	"github.com/spf13/cobra"
 )
 
@@ -19,25 +26,426 @@ var addCmd = &cobra.Command{
 	Use:   "add --trigger trigger --item item",
 	Short: "Add selected item to history",
 	Args:  cobra.NoArgs,
-	Run: func(cmd *cobra.Command, args []string) {
-		// For recording history the structure doesn't matter, as long as it's valid JSON.
-		if err := json.Unmarshal([]byte(addCmdArgs.item), &alfred.Item{}); err != nil {
-			log.Fatalf("invalid item json: %v", err)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := history.Add(triggers()[0], addCmdArgs.query, addCmdArgs.item, time.Now().Unix()); err != nil {
+			return fmt.Errorf("failed to add item to history: %v", err)
 		}
-		db, err := history.Open()
+		return nil
+	},
+}
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune [--older_than age] [--max_per_trigger n] [--trigger trigger]",
+	Short: "Remove old items from history",
+	Long: `Remove old items from history.
+
+Without --older_than and --max_per_trigger the retention from the config is applied, which also
+happens whenever an item is added. Age is a duration like 720h or a number of days like 30d. All
+triggers are pruned unless --trigger is given.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := history.Retention
+		if cmd.Flags().Changed("older_than") || cmd.Flags().Changed("max_per_trigger") {
+			opts = history.PruneOptions{MaxPerTrigger: pruneCmdArgs.maxPerTrigger}
+			if pruneCmdArgs.olderThan != "" {
+				age, err := parseAge(pruneCmdArgs.olderThan)
+				if err != nil {
+					return err
+				}
+				opts.OlderThan = age
+			}
+		}
+		opts.Triggers = selectedTriggers()
+		removed, err := history.Prune(opts)
+		if err != nil {
+			return fmt.Errorf("failed to prune history: %v", err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "removed %d items\n", removed)
+		return nil
+	},
+}
+
+// parseAge parses a duration that may also be a number of days, "30d".
+func parseAge(s string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(s, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	age, err := time.ParseDuration(s)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return age, nil
+}
+
+var pruneCmdArgs struct {
+	olderThan     string
+	maxPerTrigger int
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list [--query query] [--trigger trigger]",
+	Short: "Output history items as alfred items, with cmd to remove an item",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := history.List(selectedTriggers())
+		if err != nil {
+			return fmt.Errorf("failed to read history: %v", err)
+		}
+		matcher := match.New(listCmdArgs.query)
+		var scored []scoredItem
+		for i := range entries {
+			e := &entries[i]
+			item := e.Decode()
+			r, ok := matcher.Match(item.Title)
+			if !ok {
+				continue
+			}
+			subtitle := fmt.Sprintf("%s, used %d times", e.Trigger, e.Count)
+			if item.Subtitle != "" {
+				subtitle += ": " + item.Subtitle
+			}
+			item.Uid = ""
+			item.Title = fmt.Sprintf("%s: %s", history.FormatAge(e.LastUsed), item.Title)
+			item.Subtitle = subtitle
+			item.Mods = alfred.Mods{
+				"cmd": {
+					Arg:       strconv.FormatInt(e.Id, 10),
+					Subtitle:  "remove from history",
+					Variables: &alfred.Variables{Action: "delete", Query: listCmdArgs.query},
+				},
+			}
+			// Entries are listed most recently used first, which breaks ties of the match score.
+			scored = append(scored, scoredItem{item, r.Score*len(entries) + len(entries) - i})
+		}
+		items := sortItems(scored)
+		if items == nil {
+			items = []alfred.Item{}
+		}
+		return printJson(alfred.Result{Items: items})
+	},
+}
+
+var listCmdArgs struct {
+	query string
+}
+
+var rmCmd = &cobra.Command{
+	Use:   "rm {--id id ... | --match regexp} [--trigger trigger]",
+	Short: "Remove items from history by ID or by a regexp matching their title, subtitle or arg",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var (
+			removed int64
+			err     error
+		)
+		if len(rmCmdArgs.ids) > 0 {
+			removed, err = history.Remove(rmCmdArgs.ids)
+		} else if rmCmdArgs.match != "" {
+			re, reErr := regexp.Compile(rmCmdArgs.match)
+			if reErr != nil {
+				return fmt.Errorf("invalid --match: %v", reErr)
+			}
+			removed, err = history.RemoveMatching(re, selectedTriggers())
+		} else {
+			return errors.New("either --id or --match is required")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to remove history items: %v", err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "removed %d items\n", removed)
+		return nil
+	},
+}
+
+var rmCmdArgs struct {
+	ids   []int64
+	match string
+}
+
+var historyExportCmd = &cobra.Command{
+	Use:   "export [--output path] [--trigger trigger]",
+	Short: "Export history as JSON lines",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		w := cmd.OutOrStdout()
+		if historyExportCmdArgs.output != "" {
+			f, err := os.Create(historyExportCmdArgs.output)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := history.Export(w, selectedTriggers()); err != nil {
+			return fmt.Errorf("failed to export history: %v", err)
+		}
+		return nil
+	},
+}
+
+var historyExportCmdArgs struct {
+	output string
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import [path]",
+	Short: "Merge history exported by history export, reads stdin without a path",
+	Args:  cobra.MaximumNArgs(1),
+	// The server doesn't have the standard input of its clients.
+	Annotations: map[string]string{inProcessAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		r := cmd.InOrStdin()
+		if len(args) > 0 {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		imported, err := history.Import(r)
+		if err != nil {
+			return fmt.Errorf("failed to import history: %v", err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "imported %d items\n", imported)
+		return nil
+	},
+}
+
+var syncCmd = &cobra.Command{
+	Use:   "sync --dir dir",
+	Short: "Merge history with other machines through a synced directory",
+	Long: `Merge history with other machines through a synced directory.
+
+Every machine appends its changes to its own log in the directory, which is meant to be synced by
+Syncthing, Dropbox or similar, and rebuilds its history from the logs of all machines. Running sync
+again, or on several machines at once, gives the same result. Machines periodically write snapshots
+of the merged history to the directory and drop changes older than a week that a snapshot covers
+from their logs, a machine that didn't sync for longer than that may need to wait for the directory
+to catch up. Pruning, including retention applied after every sync, is synced as deletions.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := os.MkdirAll(syncCmdArgs.dir, 0700); err != nil {
+			return err
+		}
+		stats, err := history.Sync(syncCmdArgs.dir)
+		if err != nil {
+			return fmt.Errorf("failed to sync history: %v", err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "exported %d changes, replayed %d changes of %d hosts, compacted %d changes\n", stats.Exported, stats.Changes, stats.Hosts, stats.Compacted)
+		return nil
+	},
+}
+
+var syncCmdArgs struct {
+	dir string
+}
+
+var redactCmd = &cobra.Command{
+	Use:   "redact [--host host ...] [--query regexp ...]",
+	Short: "Remove history items denied by privacy settings of the config and by the given patterns",
+	Long: `Remove history items denied by privacy settings of the config and by the given patterns.
+
+Query patterns can't match hashed queries, items selected with them are matched by their uid,
+title, arg and autocomplete instead. Removals are synced like other deletions, change logs already
+in the sync directory keep the removed items until they are compacted.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rules := history.Privacy
+		rules.DenyHosts = append(append([]string{}, rules.DenyHosts...), redactCmdArgs.hosts...)
+		rules.DenyQueries = append([]*regexp.Regexp{}, rules.DenyQueries...)
+		for _, expr := range redactCmdArgs.queries {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return fmt.Errorf("invalid --query: %v", err)
+			}
+			rules.DenyQueries = append(rules.DenyQueries, re)
+		}
+		removed, err := history.Redact(rules)
 		if err != nil {
-			log.Fatalf("failed to open history database: %v", err)
+			return fmt.Errorf("failed to redact history: %v", err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "removed %d items\n", removed)
+		return nil
+	},
+}
+
+var redactCmdArgs struct {
+	hosts, queries []string
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats [--format alfred|text|json] [--top n] [--days n]",
+	Short: "Show what history says about workflow usage",
+	Long: `Show what history says about workflow usage.
+
+Top items and selection counts per trigger cover the whole history. Selections per day and hour
+and the share of selections suggested from history cover the last --days days. They include
+selections logged before history kept aggregated counts, but not those made by versions that kept
+counts without logging individual selections. Canonical triggers of alias groups in the config
+and triggers given with --trigger that have no history are reported as unused.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if statsCmdArgs.days < 1 || statsCmdArgs.top < 0 {
+			return errors.New("--days must be positive and --top can't be negative")
+		}
+		var known []string
+		for _, group := range cfg.TriggerAliases {
+			known = append(known, group[0])
+		}
+		for _, t := range rootCmdArgs.triggers {
+			if canonical := cfg.TriggerGroup(t)[0]; !contains(known, canonical) {
+				known = append(known, canonical)
+			}
+		}
+		stats, err := history.ComputeStats(known, statsCmdArgs.top, statsCmdArgs.days)
+		if err != nil {
+			return fmt.Errorf("failed to compute history stats: %v", err)
+		}
+		switch statsCmdArgs.format {
+		case "alfred":
+			return printJson(alfred.Result{Items: statsItems(&stats)})
+		case "json":
+			return printJson(stats)
+		case "text":
+			writeStatsText(cmd.OutOrStdout(), &stats)
+		default:
+			return fmt.Errorf("unknown format %q", statsCmdArgs.format)
 		}
-		defer db.Close()
-		if _, err := db.Exec(
-			"INSERT INTO items (ts, trigger, query, item) VALUES (?, ?, ?, ?)",
-			time.Now().Unix(), rootCmdArgs.trigger, addCmdArgs.query, addCmdArgs.item,
-		); err != nil {
-			log.Fatal(err)
+		return nil
+	},
+}
+
+var statsCmdArgs struct {
+	format    string
+	top, days int
+}
+
+func statsItems(stats *history.Stats) []alfred.Item {
+	items := []alfred.Item{}
+	for _, t := range stats.Triggers {
+		var titles []string
+		for _, s := range t.Top {
+			titles = append(titles, fmt.Sprintf("%s (%d)", s.Title, s.Count))
+		}
+		items = append(items, alfred.Item{
+			Title:    fmt.Sprintf("%s: %d selections of %d items", triggerName(t.Trigger), t.Selections, t.Items),
+			Subtitle: strings.Join(titles, ", "),
+		})
+	}
+	total := stats.FromHistory + stats.Fresh
+	if total > 0 {
+		items = append(items, alfred.Item{
+			Title:    fmt.Sprintf("%d%% of selections suggested from history", stats.FromHistory*100/total),
+			Subtitle: fmt.Sprintf("%d from history, %d fresh in the last %d days", stats.FromHistory, stats.Fresh, len(stats.Days)),
+		})
+		var days []int
+		for _, d := range stats.Days {
+			days = append(days, d.Count)
+		}
+		busiest := 0
+		for h, n := range stats.Hours {
+			if n > stats.Hours[busiest] {
+				busiest = h
+			}
+		}
+		items = append(items, alfred.Item{
+			Title:    "selections per day: " + sparkline(days),
+			Subtitle: fmt.Sprintf("%d selections since %s, busiest hour %02d:00", total, stats.Days[0].Day, busiest),
+		})
+	}
+	for _, t := range stats.Unused {
+		items = append(items, alfred.Item{
+			Title:    "unused trigger: " + t,
+			Subtitle: "no selections recorded, rename with history retrigger or remove it from the workflow",
+		})
+	}
+	return items
+}
+
+func writeStatsText(out io.Writer, stats *history.Stats) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TRIGGER\tSELECTIONS\tITEMS\tTOP")
+	for _, t := range stats.Triggers {
+		top := ""
+		if len(t.Top) > 0 {
+			top = fmt.Sprintf("%s (%d)", t.Top[0].Title, t.Top[0].Count)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", triggerName(t.Trigger), t.Selections, t.Items, top)
+		for i, s := range t.Top {
+			if i > 0 {
+				fmt.Fprintf(w, "\t\t\t%s (%d)\n", s.Title, s.Count)
+			}
+		}
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "DAY\tSELECTIONS")
+	for _, d := range stats.Days {
+		fmt.Fprintf(w, "%s\t%d\n", d.Day, d.Count)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "HOUR\tSELECTIONS")
+	for h, n := range stats.Hours {
+		fmt.Fprintf(w, "%02d\t%d\n", h, n)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "from history\t%d\nfresh\t%d\n", stats.FromHistory, stats.Fresh)
+	if len(stats.Unused) > 0 {
+		fmt.Fprintf(w, "unused triggers\t%s\n", strings.Join(stats.Unused, ", "))
+	}
+	w.Flush()
+}
+
+// triggerName shows the empty trigger, which selections are recorded under without --trigger.
+func triggerName(trigger string) string {
+	if trigger == "" {
+		return "(none)"
+	}
+	return trigger
+}
+
+// sparkline draws counts as a line of bar characters scaled to the largest count.
+func sparkline(counts []int) string {
+	bars := []rune("▁▂▃▄▅▆▇█")
+	largest := 0
+	for _, n := range counts {
+		if n > largest {
+			largest = n
+		}
+	}
+	var b strings.Builder
+	for _, n := range counts {
+		if largest == 0 {
+			b.WriteRune(bars[0])
+		} else {
+			b.WriteRune(bars[n*(len(bars)-1)/largest])
+		}
+	}
+	return b.String()
+}
+
+var retriggerCmd = &cobra.Command{
+	Use:   "retrigger --from trigger --to trigger",
+	Short: "Move history of a trigger to another one, like after renaming an Alfred keyword",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		moved, err := history.Retrigger(retriggerCmdArgs.from, retriggerCmdArgs.to)
+		if err != nil {
+			return fmt.Errorf("failed to retrigger history: %v", err)
 		}
+		fmt.Fprintf(cmd.OutOrStdout(), "moved %d items\n", moved)
+		return nil
 	},
 }
 
+var retriggerCmdArgs struct {
+	from, to string
+}
+
 var addCmdArgs struct {
 	item  string
 	query string
@@ -48,4 +456,31 @@ func init() {
 	historyCmd.AddCommand(addCmd)
 	addCmd.Flags().StringVarP(&addCmdArgs.item, "item", "i", "", "JSON string of the alfred item to add to history")
 	addCmd.Flags().StringVar(&addCmdArgs.query, "query", "", "Alfred input query")
+	historyCmd.AddCommand(listCmd)
+	listCmd.Flags().StringVar(&listCmdArgs.query, "query", "", "Only list items whose title matches the query")
+	historyCmd.AddCommand(rmCmd)
+	rmCmd.Flags().Int64SliceVar(&rmCmdArgs.ids, "id", nil, "ID of the history item to remove, as output by history list")
+	rmCmd.Flags().StringVar(&rmCmdArgs.match, "match", "", "Remove items whose title, subtitle or arg match the regexp")
+	rmCmd.MarkFlagsMutuallyExclusive("id", "match")
+	historyCmd.AddCommand(historyExportCmd)
+	historyExportCmd.Flags().StringVar(&historyExportCmdArgs.output, "output", "", "File to write, stdout when empty")
+	historyCmd.AddCommand(importCmd)
+	historyCmd.AddCommand(retriggerCmd)
+	retriggerCmd.Flags().StringVar(&retriggerCmdArgs.from, "from", "", "Trigger to move history from")
+	retriggerCmd.Flags().StringVar(&retriggerCmdArgs.to, "to", "", "Trigger to move history to")
+	retriggerCmd.MarkFlagRequired("from")
+	retriggerCmd.MarkFlagRequired("to")
+	historyCmd.AddCommand(statsCmd)
+	statsCmd.Flags().StringVar(&statsCmdArgs.format, "format", "alfred", "Output format: alfred, text or json")
+	statsCmd.Flags().IntVar(&statsCmdArgs.top, "top", 5, "Number of top items per trigger")
+	statsCmd.Flags().IntVar(&statsCmdArgs.days, "days", 14, "Number of days of daily and hourly counts")
+	historyCmd.AddCommand(redactCmd)
+	redactCmd.Flags().StringSliceVar(&redactCmdArgs.hosts, "host", nil, "Also remove items with URLs of the host and its subdomains")
+	redactCmd.Flags().StringArrayVar(&redactCmdArgs.queries, "query", nil, "Also remove items selected with a query matching the regexp")
+	historyCmd.AddCommand(syncCmd)
+	syncCmd.Flags().StringVar(&syncCmdArgs.dir, "dir", "", "Synced directory with change logs of all machines")
+	syncCmd.MarkFlagRequired("dir")
+	historyCmd.AddCommand(pruneCmd)
+	pruneCmd.Flags().StringVar(&pruneCmdArgs.olderThan, "older_than", "", "Remove items not used for this long, like 90d")
+	pruneCmd.Flags().IntVar(&pruneCmdArgs.maxPerTrigger, "max_per_trigger", 0, "Keep at most this many most recently used items per trigger")
 }
@@ -2,11 +2,12 @@ package cmd
 
 import (
 	"database/sql"
-	"log"
-	"strings"
+	"errors"
 
 	"github.com/solodov/org-roam-alfred-items/alfred"
+	"github.com/solodov/org-roam-alfred-items/config"
 	"github.com/solodov/org-roam-alfred-items/history"
+	"github.com/solodov/org-roam-alfred-items/match"
 	"github.com/solodov/org-roam-alfred-items/roam"
 	"github.com/spf13/cobra"
 )
@@ -15,10 +16,14 @@ var booksCmd = &cobra.Command{
 	Use:   "books [--query query]",
 	Short: "Output books alfred items matching the argument",
 	Args:  cobra.NoArgs,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		category, err := booksCategory()
+		if category == nil {
+			return err
+		}
 		db, err := sql.Open("sqlite3", roamCmdArgs.dbPath)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		defer db.Close()
 		row, err := db.Query(`
@@ -27,55 +32,85 @@ var booksCmd = &cobra.Command{
 			INNER JOIN files ON nodes.file = files.file
 			WHERE nodes.level == 2 AND files.file LIKE '%/books.org%'`)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-		items := []alfred.Item{
-			alfred.Item{
+		items := []alfred.Item{}
+		if e := cfg.SearchEngine(booksCmdArgs.engine); e != nil {
+			items = append(items, alfred.Item{
 				Title:        booksCmdArgs.query,
-				Subtitle:     "search goodreads for " + booksCmdArgs.query,
-				Arg:          "https://www.goodreads.com/search?q=" + booksCmdArgs.query,
+				Subtitle:     e.ItemTitle(booksCmdArgs.query),
+				Arg:          e.Expand(booksCmdArgs.query),
 				Autocomplete: booksCmdArgs.query,
 				Variables: alfred.Variables{
-					Profile: "home",
+					Profile: category.BrowserProfile(),
 					Query:   booksCmdArgs.query,
 				},
-				Save: true,
-			},
+				Save:      true,
+				FromQuery: true,
+			})
 		}
-		items = append(items, history.FindMatchingItems(rootCmdArgs.trigger, booksCmdArgs.query)...)
+		matcher := match.New(booksCmdArgs.query)
+		var books []scoredItem
 		for row.Next() {
 			var props roam.Props
 			if err := row.Scan(&props); err != nil {
-				log.Fatal(err)
+				return err
 			}
 			if data, err := props.ItemLinkData(); err != nil {
 				continue
-			} else if strings.Contains(strings.ToLower(data.Title), strings.ToLower(booksCmdArgs.query)) {
-				items = append(
-					items,
-					alfred.Item{
-						Title:        data.Title,
-						Subtitle:     data.Url,
-						Arg:          data.Url,
-						Autocomplete: data.Title,
-						Variables: alfred.Variables{
-							Profile: "home",
-							Query:   booksCmdArgs.query,
+			} else if r, ok := matcher.Match(data.Title); ok {
+				profile := category.BrowserProfile()
+				if c := cfg.Category(props.Category); c != nil {
+					profile = c.BrowserProfile()
+				}
+				books = append(
+					books,
+					scoredItem{
+						alfred.Item{
+							Uid:          "book:" + data.Url,
+							Title:        data.Title,
+							Subtitle:     data.Url,
+							Arg:          data.Url,
+							Autocomplete: data.Title,
+							Variables: alfred.Variables{
+								Profile: profile,
+								Query:   booksCmdArgs.query,
+							},
+							Save: true,
 						},
+						r.Score,
 					},
 				)
 			}
 		}
+		addFrecency(books, booksCmdArgs.query)
+		ranked := sortItems(books)
+		items = append(items, historySuggestions(booksCmdArgs.query, ranked)...)
+		items = append(items, ranked...)
 		history.FinalizeItems(&items)
-		printJson(alfred.Result{Items: items})
+		return printJson(alfred.Result{Items: items})
 	},
 }
 
+// booksCategory returns the category of books without one, the first configured category unless
+// --category is given.
+func booksCategory() (*config.Category, error) {
+	if booksCmdArgs.category != "" {
+		return lookupCategory(booksCmdArgs.category)
+	}
+	if len(cfg.Categories) == 0 {
+		return nil, errors.New("no categories configured")
+	}
+	return &cfg.Categories[0], nil
+}
+
 var booksCmdArgs struct {
-	query string
+	query, engine, category string
 }
 
 func init() {
 	roamCmd.AddCommand(booksCmd)
 	booksCmd.Flags().StringVar(&booksCmdArgs.query, "query", "", "Alfred input query")
+	booksCmd.Flags().StringVar(&booksCmdArgs.category, "category", "", "Category whose browser profile opens books without a category, the first configured one by default")
+	booksCmd.Flags().StringVar(&booksCmdArgs.engine, "engine", "goodreads", "Search engine of the query item, none if it's not configured")
 }
@@ -6,7 +6,7 @@ package cmd
 import (
 	"database/sql"
 	"fmt"
-	"log"
+	"math"
 	"net/url"
 	"os"
 	"os/user"
@@ -15,7 +15,9 @@ import (
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/solodov/org-roam-alfred-items/alfred"
+	"github.com/solodov/org-roam-alfred-items/config"
 	"github.com/solodov/org-roam-alfred-items/history"
+	"github.com/solodov/org-roam-alfred-items/match"
 	"github.com/solodov/org-roam-alfred-items/roam"
 	"github.com/spf13/cobra"
 )
@@ -24,10 +26,15 @@ var chromeCmd = &cobra.Command{
 	Use:   "chrome --category cat [--query query]",
 	Short: "Output chrome alfred items matching the argument",
 	Args:  cobra.NoArgs,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		iconExists = map[string]bool{}
+		category, err := lookupCategory(chromeCmdArgs.category)
+		if category == nil {
+			return err
+		}
 		db, err := sql.Open("sqlite3", roamCmdArgs.dbPath)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		defer db.Close()
 		rows, err := db.Query(`
@@ -36,49 +43,104 @@ var chromeCmd = &cobra.Command{
 			INNER JOIN files ON nodes.file = files.file
 			WHERE nodes.level == 2 AND files.file LIKE '%/chrome.org%'`)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		var (
-			props roam.Props
-			items []alfred.Item
+			props        roam.Props
+			links        []scoredItem
+			keywordItems []alfred.Item
 		)
+		matcher := match.New(chromeCmdArgs.query)
+		keyword, keywordQuery, _ := strings.Cut(chromeCmdArgs.query, " ")
 		for rows.Next() {
 			if err := rows.Scan(&props); err != nil {
-				log.Fatal(err)
+				return err
 			}
-			if props.Category != chromeCmdArgs.category {
+			if !category.Sees(props.Category) {
 				continue
 			}
-			if data, err := props.ItemLinkData(); err != nil {
+			data, err := props.ItemLinkData()
+			if err != nil {
 				continue
-			} else if strings.Contains(data.Title, chromeCmdArgs.query) || strings.Contains(props.Aliases, chromeCmdArgs.query) {
-				items = append(
-					items,
-					alfred.Item{
-						Title:        data.Title,
-						Subtitle:     data.Url,
-						Arg:          data.Url,
-						Autocomplete: data.Url,
-						Icon:         pickIcon(props.Icon, strings.ReplaceAll(data.Title, " ", "_")),
-						Variables:    alfred.Variables{BrowserOverride: props.BrowserOverride, NewWindow: props.NewWindow},
+			}
+			if props.Keyword != "" && props.Keyword == keyword && keywordQuery != "" {
+				keywordItems = append(keywordItems, makeKeywordItem(props, data.Title, data.Url, keywordQuery))
+			} else if score, ok := scoreLink(matcher, data.Title, props); ok {
+				// Templated links without a query open the bare URL.
+				url := config.ExpandUrl(data.Url, "query", "")
+				links = append(
+					links,
+					scoredItem{
+						alfred.Item{
+							Uid:          "link:" + data.Url,
+							Title:        data.Title,
+							Subtitle:     url,
+							Arg:          url,
+							Autocomplete: url,
+							Icon:         pickIcon(props.Icon, strings.ReplaceAll(data.Title, " ", "_")),
+							Variables:    alfred.Variables{BrowserOverride: props.BrowserOverride, NewWindow: props.NewWindow},
+							Save:         true,
+						},
+						score,
 					})
-				if len(items) == 1 {
-					items = append(items, makeDynamicItems(chromeCmdArgs.query)...)
-				}
 			}
 		}
-		if len(items) == 0 {
-			items = makeDynamicItems(chromeCmdArgs.query)
+		addFrecency(links, chromeCmdArgs.query)
+		ranked := sortItems(links)
+		dynamic := makeDynamicItems(chromeCmdArgs.query, append(ranked, keywordItems...))
+		items := dynamic
+		if len(ranked) > 0 {
+			// Dynamic items go right after the best matching link.
+			items = append(ranked[:1:1], append(dynamic, ranked[1:]...)...)
 		}
+		items = append(keywordItems, items...)
 		for i := range items {
-			items[i].Variables.Profile = chromeCmdArgs.category
+			items[i].Variables.Profile = category.BrowserProfile()
 		}
 		history.FinalizeItems(&items)
-		printJson(alfred.Result{Items: items})
+		return printJson(alfred.Result{Items: items})
 	},
 }
 
-func makeDynamicItems(alfredQuery string) (items []alfred.Item) {
+// scoreLink matches the query against the link title and aliases, typing the exact keyword of a
+// link makes it the best match.
+func scoreLink(matcher *match.Matcher, title string, props roam.Props) (score int, ok bool) {
+	if props.Keyword != "" && props.Keyword == chromeCmdArgs.query {
+		return math.MaxInt, true
+	}
+	if r, found := matcher.Match(title); found {
+		score, ok = r.Score, true
+	}
+	if r, found := matcher.Match(props.Aliases); found && props.Aliases != "" && (!ok || r.Score > score) {
+		score, ok = r.Score, true
+	}
+	return score, ok
+}
+
+// makeKeywordItem expands the templated link of a chrome.org entry whose KEYWORD matched the first
+// word of the query, the rest of the query is substituted into the URL.
+func makeKeywordItem(props roam.Props, title, urlTemplate, query string) alfred.Item {
+	url := config.ExpandUrl(urlTemplate, "query", query)
+	return alfred.Item{
+		Uid:          "keyword:" + props.Keyword + ":" + query,
+		Title:        fmt.Sprintf(`%s "%v"`, title, query),
+		Subtitle:     url,
+		Arg:          url,
+		Autocomplete: chromeCmdArgs.query,
+		Icon:         pickIcon(props.Icon, strings.ReplaceAll(title, " ", "_")),
+		Variables: alfred.Variables{
+			BrowserOverride: props.BrowserOverride,
+			NewWindow:       props.NewWindow,
+			Query:           chromeCmdArgs.query,
+		},
+		Save:      true,
+		FromQuery: true,
+	}
+}
+
+// makeDynamicItems returns items for the query itself, search items and history suggestions except
+// the shown items.
+func makeDynamicItems(alfredQuery string, shown []alfred.Item) (items []alfred.Item) {
 	if alfredQuery == "" {
 		return items
 	}
@@ -91,99 +153,60 @@ func makeDynamicItems(alfredQuery string) (items []alfred.Item) {
 				Icon:      pickIcon("chrome"),
 				Variables: alfred.Variables{Query: alfredQuery},
 				Save:      true,
+				FromQuery: true,
 			})
-	} else if chromeCmdArgs.category == "home" {
-		items = append(
-			items,
-			alfred.Item{
-				Title:        fmt.Sprintf(`search google for "%v"`, alfredQuery),
-				Arg:          "https://www.google.com/search?q=" + alfredQuery,
-				Autocomplete: alfredQuery,
-				Icon:         pickIcon("chrome"),
-				Variables:    alfred.Variables{Query: alfredQuery},
-				Save:         true,
-			},
-			alfred.Item{
-				Title:        fmt.Sprintf(`search map for "%v"`, alfredQuery),
-				Arg:          "https://www.google.com/maps/search/" + alfredQuery,
-				Autocomplete: alfredQuery,
-				Icon:         pickIcon("map"),
-				Variables:    alfred.Variables{Query: alfredQuery},
-				Save:         true,
-			},
-			alfred.Item{
-				Title:        fmt.Sprintf(`search youtube for "%v"`, alfredQuery),
-				Arg:          "https://www.youtube.com/results?search_query=" + alfredQuery,
-				Autocomplete: alfredQuery,
-				Icon:         pickIcon("youtube"),
-				Variables:    alfred.Variables{Query: alfredQuery},
-				Save:         true,
-			},
-		)
-		items = append(items, history.FindMatchingItems(rootCmdArgs.trigger, alfredQuery)...)
-	} else if chromeCmdArgs.category == "goog" {
+	} else {
+		items = append(items, makeSearchItems(alfredQuery)...)
+		items = append(items, historySuggestions(alfredQuery, shown)...)
+	}
+	return items
+}
+
+// makeSearchItems returns items for search engines of the current category. When the query starts
+// with a keyword of one of the engines, only that engine is used with the rest of the query.
+func makeSearchItems(alfredQuery string) (items []alfred.Item) {
+	engines := cfg.EnginesFor(chromeCmdArgs.category)
+	if keyword, rest, found := strings.Cut(alfredQuery, " "); found && rest != "" {
+		for _, e := range engines {
+			if e.Keyword != "" && e.Keyword == keyword {
+				engines = []config.SearchEngine{e}
+				alfredQuery = rest
+				break
+			}
+		}
+	}
+	for _, e := range engines {
 		items = append(
 			items,
 			alfred.Item{
-				Title:        fmt.Sprintf(`search moma for "%v"`, alfredQuery),
-				Arg:          "https://moma.corp.google.com/search?q=" + alfredQuery,
-				Autocomplete: alfredQuery,
-				Icon:         pickIcon("moma"),
-				Variables:    alfred.Variables{Query: alfredQuery},
-				Save:         true,
-			},
-			alfred.Item{
-				Title:        fmt.Sprintf(`code search for "%v"`, alfredQuery),
-				Arg:          "https://source.corp.google.com/search?q=" + alfredQuery,
-				Autocomplete: alfredQuery,
-				Icon:         pickIcon("cs"),
-				Variables:    alfred.Variables{Query: alfredQuery},
-				Save:         true,
-			},
-			alfred.Item{
-				Title:        fmt.Sprintf(`search google for "%v"`, alfredQuery),
-				Arg:          "https://www.google.com/search?q=" + alfredQuery,
-				Autocomplete: alfredQuery,
-				Icon:         pickIcon("search"),
-				Variables:    alfred.Variables{Query: alfredQuery},
-				Save:         true,
-			},
-			alfred.Item{
-				Title:        fmt.Sprintf(`search glossary for "%v"`, alfredQuery),
-				Arg:          "https://moma.corp.google.com/search?hq=type:glossary&q=" + alfredQuery,
-				Autocomplete: alfredQuery,
-				Icon:         pickIcon("glossary"),
-				Variables:    alfred.Variables{Query: alfredQuery},
-				Save:         true,
-			},
-			alfred.Item{
-				Title:        fmt.Sprintf(`search who for "%v"`, alfredQuery),
-				Arg:          "https://moma.corp.google.com/search?hq=type:people&q=" + alfredQuery,
-				Autocomplete: alfredQuery,
-				Icon:         pickIcon("who"),
-				Variables:    alfred.Variables{Query: alfredQuery},
-				Save:         true,
-			},
-			alfred.Item{
-				Title:        fmt.Sprintf(`search go links for "%v"`, alfredQuery),
-				Arg:          "https://moma.corp.google.com/go2/search?q=" + alfredQuery,
+				Uid:          "search:" + e.Name + ":" + alfredQuery,
+				Title:        e.ItemTitle(alfredQuery),
+				Arg:          e.Expand(alfredQuery),
 				Autocomplete: alfredQuery,
-				Icon:         pickIcon("go_links"),
+				Icon:         pickIcon(e.Icon),
 				Variables:    alfred.Variables{Query: alfredQuery},
 				Save:         true,
-			},
-		)
-		history.FinalizeItems(&items)
-		items = append(items, history.FindMatchingItems(rootCmdArgs.trigger, alfredQuery)...)
+				FromQuery:    true,
+			})
 	}
 	return items
 }
 
+// iconExists caches icon lookups during a command run, icons may change between requests to the
+// server.
+var iconExists = map[string]bool{}
+
 func pickIcon(bases ...string) (icon alfred.Icon) {
 	dir := filepath.Join(chromeCmdArgs.orgDir, "alfred", "images")
 	for _, base := range bases {
 		path := filepath.Join(dir, base+".png")
-		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		exists, found := iconExists[path]
+		if !found {
+			info, err := os.Stat(path)
+			exists = err == nil && !info.IsDir()
+			iconExists[path] = exists
+		}
+		if exists {
 			icon.Path = path
 			break
 		}
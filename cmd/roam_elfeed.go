@@ -5,8 +5,8 @@ package cmd
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
 	"strings"
 
 	"github.com/solodov/org-roam-alfred-items/alfred"
@@ -24,8 +24,12 @@ var elfeedItemsCmd = &cobra.Command{
 	DisableFlagsInUseLine: true,
 	Short:                 "Output elfeed alfred items",
 	Args:                  cobra.NoArgs,
-	Run: func(cmd *cobra.Command, args []string) {
-		printJson(alfred.Result{Items: readElfeedItems()})
+	RunE: func(cmd *cobra.Command, args []string) error {
+		items, err := readElfeedItems()
+		if err != nil {
+			return err
+		}
+		return printJson(alfred.Result{Items: items})
 	},
 }
 
@@ -34,21 +38,25 @@ var elfeedResolveCmd = &cobra.Command{
 	DisableFlagsInUseLine: true,
 	Short:                 "Resolve elfeed title to its link",
 	Args:                  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		for _, item := range readElfeedItems() {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		items, err := readElfeedItems()
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
 			if item.Title == args[0] {
-				fmt.Print(item.Arg)
-				return
+				fmt.Fprint(cmd.OutOrStdout(), item.Arg)
+				return nil
 			}
 		}
-		log.Fatalf("not found")
+		return errors.New("not found")
 	},
 }
 
-func readElfeedItems() (items []alfred.Item) {
+func readElfeedItems() (items []alfred.Item, err error) {
 	db, err := sql.Open("sqlite3", roamCmdArgs.dbPath)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer db.Close()
 	rows, err := db.Query(`
@@ -57,12 +65,12 @@ func readElfeedItems() (items []alfred.Item) {
 		INNER JOIN files ON nodes.file = files.file
 		WHERE nodes.level == 2 AND files.file LIKE '%/feeds.org%'`)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	var props roam.Props
 	for rows.Next() {
 		if err := rows.Scan(&props); err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		if _, found := props.Tags["fomo"]; found {
 			if data, err := props.ItemLinkData(); err == nil {
@@ -81,7 +89,7 @@ func readElfeedItems() (items []alfred.Item) {
 			}
 		}
 	}
-	return items
+	return items, rows.Err()
 }
 
 func init() {
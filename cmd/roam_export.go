@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/solodov/org-roam-alfred-items/org"
+	"github.com/solodov/org-roam-alfred-items/roam"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export --id id [--format markdown|html|text] [--output path]",
+	Short: "Export the node subtree to Markdown, HTML or plain text",
+	Long: `Export the node subtree to Markdown, HTML or plain text.
+
+Links to other nodes are rendered according to --id_links: "title" keeps only the link
+description, or the linked node title for links without one, "relative" links to <id>.md or
+<id>.html next to the exported file, which is where other notes end up when exported with the
+same settings, and "publish" uses publish_url from the config.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch exportCmdArgs.idLinks {
+		case "title", "relative", "publish":
+		default:
+			return fmt.Errorf("unknown id_links value %q", exportCmdArgs.idLinks)
+		}
+		index, err := loadNodeIndex()
+		if err != nil {
+			return err
+		}
+		var entry *roam.IndexEntry
+		for i := range index.Entries {
+			if index.Entries[i].Id == exportCmdArgs.id {
+				entry = &index.Entries[i]
+				break
+			}
+		}
+		if entry == nil {
+			return fmt.Errorf("node %v not found", exportCmdArgs.id)
+		}
+		blocks, err := readNodeBlocks(entry, map[string]string{})
+		if err != nil {
+			return err
+		}
+		if entry.Level == 0 {
+			// File nodes have their title in a keyword, which the parser drops.
+			blocks = append([]org.Block{{Kind: org.Heading, Text: entry.Name}}, blocks...)
+		}
+		titles := map[string]string{}
+		for _, e := range index.Entries {
+			titles[e.Id] = e.Name
+		}
+		opts := org.Options{
+			ResolveLink: resolveExportLink,
+			// Links to nodes without a description show the node title rather than its id.
+			DescribeLink: func(target string) string {
+				id, _ := strings.CutPrefix(target, "id:")
+				return titles[id]
+			},
+		}
+		var out string
+		switch exportCmdArgs.format {
+		case "markdown":
+			out = org.Markdown(blocks, opts)
+		case "html":
+			out = org.HTMLPage(entry.Name, org.HTML(blocks, opts))
+		case "text":
+			out = org.PlainTextBlocks(blocks) + "\n"
+		default:
+			return fmt.Errorf("unknown export format %q", exportCmdArgs.format)
+		}
+		if exportCmdArgs.output == "" {
+			cmd.OutOrStdout().Write([]byte(out))
+		} else if err := os.WriteFile(exportCmdArgs.output, []byte(out), 0644); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+// resolveExportLink turns id: links into links to other exported notes, web and mailto links are
+// kept, everything else is rendered as the link description.
+func resolveExportLink(target string) string {
+	id, isId := strings.CutPrefix(target, "id:")
+	if !isId {
+		for _, scheme := range []string{"http:", "https:", "mailto:"} {
+			if strings.HasPrefix(target, scheme) {
+				return target
+			}
+		}
+		return ""
+	}
+	switch exportCmdArgs.idLinks {
+	case "relative":
+		ext := ".md"
+		if exportCmdArgs.format == "html" {
+			ext = ".html"
+		}
+		return url.PathEscape(id) + ext
+	case "publish":
+		return strings.ReplaceAll(cfg.PublishUrl, "{id}", url.QueryEscape(id))
+	}
+	return ""
+}
+
+var exportCmdArgs struct {
+	id, format, output, idLinks string
+}
+
+func init() {
+	roamCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringVar(&exportCmdArgs.id, "id", "", "ID of the node to export")
+	exportCmd.Flags().StringVar(&exportCmdArgs.format, "format", "markdown", "Output format: markdown, html or text")
+	exportCmd.Flags().StringVar(&exportCmdArgs.output, "output", "", "File to write, stdout when empty")
+	exportCmd.Flags().StringVar(&exportCmdArgs.idLinks, "id_links", "title", "How to render links to other nodes: title, relative or publish")
+	exportCmd.MarkFlagRequired("id")
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/solodov/org-roam-alfred-items/roam"
+	"github.com/spf13/cobra"
+)
+
+var graphCmd = &cobra.Command{
+	Use:   "graph --id id [--depth N] [--format dot|json|mermaid] [--category category]",
+	Short: "Output the link neighborhood of a node as a graph",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if graphCmdArgs.category != "" {
+			if category, err := lookupCategory(graphCmdArgs.category); category == nil {
+				return err
+			}
+		}
+		index, err := loadNodeIndex()
+		if err != nil {
+			return err
+		}
+		entries := map[string]*roam.IndexEntry{}
+		for i := range index.Entries {
+			entries[index.Entries[i].Id] = &index.Entries[i]
+		}
+		if _, found := entries[graphCmdArgs.id]; !found {
+			return fmt.Errorf("node %v not found", graphCmdArgs.id)
+		}
+		db, err := sql.Open("sqlite3", roamCmdArgs.dbPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		links, err := roam.ReadLinks(db)
+		if err != nil {
+			return err
+		}
+		ids, edges := roam.Neighborhood(links, graphCmdArgs.id, graphCmdArgs.depth, func(id string) bool {
+			e, found := entries[id]
+			return found && e.Hidden == "" && cfg.Visible(graphCmdArgs.category, e.Category)
+		})
+		nodes := make([]*roam.IndexEntry, len(ids))
+		for i, id := range ids {
+			nodes[i] = entries[id]
+		}
+		out := cmd.OutOrStdout()
+		switch graphCmdArgs.format {
+		case "dot":
+			writeDot(out, nodes, edges)
+		case "mermaid":
+			writeMermaid(out, nodes, edges)
+		case "json":
+			return printJson(makeGraphJson(nodes, edges))
+		default:
+			return fmt.Errorf("unknown format %q", graphCmdArgs.format)
+		}
+		return nil
+	},
+}
+
+func writeDot(out io.Writer, nodes []*roam.IndexEntry, edges []roam.Link) {
+	quote := func(s string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+	}
+	fmt.Fprintln(out, "digraph roam {")
+	for _, n := range nodes {
+		fmt.Fprintf(out, "  %s [label=%s, category=%s, tags=%s];\n",
+			quote(n.Id), quote(n.Name), quote(n.Category), quote(strings.Join(n.Tags, " ")))
+	}
+	for _, e := range edges {
+		fmt.Fprintf(out, "  %s -> %s;\n", quote(e.Source), quote(e.Dest))
+	}
+	fmt.Fprintln(out, "}")
+}
+
+func writeMermaid(out io.Writer, nodes []*roam.IndexEntry, edges []roam.Link) {
+	// Mermaid node IDs are restricted, nodes are numbered instead.
+	names := map[string]string{}
+	fmt.Fprintln(out, "graph LR")
+	for i, n := range nodes {
+		names[n.Id] = fmt.Sprintf("n%d", i)
+		label := n.Name
+		if len(n.Tags) > 0 {
+			label += " #" + strings.Join(n.Tags, " #")
+		}
+		fmt.Fprintf(out, "  %s[\"%s\"]\n", names[n.Id], strings.ReplaceAll(label, `"`, "#quot;"))
+	}
+	for _, e := range edges {
+		fmt.Fprintf(out, "  %s --> %s\n", names[e.Source], names[e.Dest])
+	}
+}
+
+func makeGraphJson(nodes []*roam.IndexEntry, edges []roam.Link) any {
+	type node struct {
+		Id       string   `json:"id"`
+		Title    string   `json:"title"`
+		Category string   `json:"category,omitempty"`
+		Tags     []string `json:"tags,omitempty"`
+	}
+	type edge struct {
+		Source string `json:"source"`
+		Dest   string `json:"dest"`
+	}
+	graph := struct {
+		Nodes []node `json:"nodes"`
+		Edges []edge `json:"edges"`
+	}{Nodes: []node{}, Edges: []edge{}}
+	for _, n := range nodes {
+		graph.Nodes = append(graph.Nodes, node{n.Id, n.Name, n.Category, n.Tags})
+	}
+	for _, e := range edges {
+		graph.Edges = append(graph.Edges, edge{e.Source, e.Dest})
+	}
+	return graph
+}
+
+var graphCmdArgs struct {
+	id, format, category string
+	depth                int
+}
+
+func init() {
+	roamCmd.AddCommand(graphCmd)
+	graphCmd.Flags().StringVar(&graphCmdArgs.id, "id", "", "ID of the node in the center of the graph")
+	graphCmd.Flags().IntVar(&graphCmdArgs.depth, "depth", 1, "Number of link hops to follow")
+	graphCmd.Flags().StringVar(&graphCmdArgs.format, "format", "dot", "Output format: dot, json or mermaid")
+	graphCmd.Flags().StringVar(&graphCmdArgs.category, "category", "", "Category to limit nodes to")
+	graphCmd.MarkFlagRequired("id")
+}
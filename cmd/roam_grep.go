@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/solodov/org-roam-alfred-items/alfred"
+	"github.com/solodov/org-roam-alfred-items/history"
+	"github.com/solodov/org-roam-alfred-items/roam"
+	"github.com/spf13/cobra"
+)
+
+var grepCmd = &cobra.Command{
+	Use:   "grep --query query [--category category]",
+	Short: "Search node bodies and output matching nodes as alfred items",
+	Long: `Search node bodies and output matching nodes as alfred items.
+
+Org files listed in the roam database are indexed into an SQLite FTS5 database, files are
+reindexed when their hash in the roam database changes. Binaries built without -tags sqlite_fts5
+keep the index in a plain table and match query terms as substrings instead.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if grepCmdArgs.category != "" {
+			if category, err := lookupCategory(grepCmdArgs.category); category == nil {
+				return err
+			}
+		}
+		ft, err := roam.OpenFullText(grepCmdArgs.dbPath)
+		if err != nil {
+			return err
+		}
+		defer ft.Close()
+		db, err := sql.Open("sqlite3", roamCmdArgs.dbPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if err := ft.Update(db); err != nil {
+			return fmt.Errorf("full-text index update failed: %v", err)
+		}
+		index, err := loadNodeIndex()
+		if err != nil {
+			return err
+		}
+		entries := map[string]*roam.IndexEntry{}
+		for i := range index.Entries {
+			entries[index.Entries[i].Id] = &index.Entries[i]
+		}
+		// Hidden nodes are filtered after the search, fetch more hits to make up for them.
+		hits, err := ft.Search(grepCmdArgs.query, 4*grepCmdArgs.limit)
+		if err != nil {
+			return err
+		}
+		var items []alfred.Item
+		for _, hit := range hits {
+			e, found := entries[hit.Id]
+			if !found || e.Hidden != "" || !cfg.Visible(grepCmdArgs.category, e.Category) {
+				continue
+			}
+			items = append(items, alfred.Item{
+				Uid:      e.Id,
+				Title:    e.Title,
+				Subtitle: strings.Join(strings.Fields(hit.Snippet), " "),
+				Arg:      e.Id,
+				Variables: alfred.Variables{
+					Query: grepCmdArgs.query,
+				},
+				Save: true,
+			})
+			if len(items) == grepCmdArgs.limit {
+				break
+			}
+		}
+		history.FinalizeItems(&items)
+		return printJson(alfred.Result{Items: items})
+	},
+}
+
+var grepCmdArgs struct {
+	query, category, dbPath string
+	limit                   int
+}
+
+func init() {
+	roamCmd.AddCommand(grepCmd)
+	u, _ := user.Current()
+	grepCmd.Flags().StringVar(&grepCmdArgs.query, "query", "", "Alfred input query")
+	grepCmd.Flags().StringVar(&grepCmdArgs.category, "category", "", "Category to limit items to")
+	grepCmd.Flags().StringVar(&grepCmdArgs.dbPath, "fulltext_db_path", filepath.Join(defaultDataDir(u.HomeDir), "fulltext.db"), "Path to the full-text index database")
+	envDefault(grepCmd.Flags(), "fulltext_db_path", func() string { return filepath.Join(defaultDataDir(u.HomeDir), "fulltext.db") })
+	grepCmd.Flags().IntVar(&grepCmdArgs.limit, "limit", 50, "Maximum number of items")
+	grepCmd.MarkFlagRequired("query")
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+
+	"github.com/solodov/org-roam-alfred-items/alfred"
+	"github.com/solodov/org-roam-alfred-items/roam"
+	"github.com/spf13/cobra"
+)
+
+var linkCmd = &cobra.Command{
+	Use:   "link --id id [--format org|markdown|html|plain]",
+	Short: "Print a link to the node",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		index, err := loadNodeIndex()
+		if err != nil {
+			return err
+		}
+		for i := range index.Entries {
+			if e := &index.Entries[i]; e.Id == linkCmdArgs.id {
+				link, err := nodeLink(e, linkCmdArgs.format)
+				if err != nil {
+					return err
+				}
+				fmt.Fprint(cmd.OutOrStdout(), link)
+				return nil
+			}
+		}
+		return fmt.Errorf("node %v not found", linkCmdArgs.id)
+	},
+}
+
+// nodeLink formats a link to the node. Markdown and HTML links point to the configured publishing
+// URL, plain links are file paths.
+func nodeLink(e *roam.IndexEntry, format string) (string, error) {
+	publishUrl := strings.ReplaceAll(cfg.PublishUrl, "{id}", url.QueryEscape(e.Id))
+	switch format {
+	case "org":
+		return fmt.Sprintf("[[id:%s][%s]]", e.Id, strings.NewReplacer("[", "{", "]", "}").Replace(e.Name)), nil
+	case "markdown":
+		return fmt.Sprintf("[%s](%s)", strings.NewReplacer("[", `\[`, "]", `\]`).Replace(e.Name), publishUrl), nil
+	case "html":
+		return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(publishUrl), html.EscapeString(e.Name)), nil
+	case "plain":
+		return e.File, nil
+	}
+	return "", fmt.Errorf("unknown link format %q", format)
+}
+
+// linkMods are modifiers of node items that copy links in various formats.
+var linkMods = []struct{ key, format string }{
+	{"cmd", "org"},
+	{"alt", "markdown"},
+	{"ctrl", "html"},
+	{"fn", "plain"},
+}
+
+// addLinks makes Cmd+C copy the org link of node items and adds modifiers that copy other link
+// formats, the workflow is expected to copy arg when the action variable is "copy".
+func addLinks(items []alfred.Item, index *roam.Index) {
+	entries := map[string]*roam.IndexEntry{}
+	for i := range index.Entries {
+		entries[index.Entries[i].Id] = &index.Entries[i]
+	}
+	for i := range items {
+		e, found := entries[items[i].Uid]
+		if !found {
+			continue
+		}
+		items[i].Text.Copy, _ = nodeLink(e, "org")
+		items[i].Mods = alfred.Mods{}
+		for _, mod := range linkMods {
+			link, _ := nodeLink(e, mod.format)
+			items[i].Mods[mod.key] = alfred.Mod{
+				Arg:       link,
+				Subtitle:  "copy " + mod.format + " link",
+				Variables: &alfred.Variables{Action: "copy", Query: items[i].Variables.Query},
+			}
+		}
+	}
+}
+
+var linkCmdArgs struct {
+	id, format string
+}
+
+func init() {
+	roamCmd.AddCommand(linkCmd)
+	linkCmd.Flags().StringVar(&linkCmdArgs.id, "id", "", "ID of the node")
+	linkCmd.Flags().StringVar(&linkCmdArgs.format, "format", "org", "Link format: org, markdown, html or plain")
+	linkCmd.MarkFlagRequired("id")
+}
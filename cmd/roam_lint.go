@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/solodov/org-roam-alfred-items/alfred"
+	"github.com/solodov/org-roam-alfred-items/roam"
+	"github.com/spf13/cobra"
+)
+
+var lintCmd = &cobra.Command{
+	Use:   "lint [--format alfred|text|json]",
+	Short: "Report orphan nodes, broken links and other problems in the roam database",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := sql.Open("sqlite3", roamCmdArgs.dbPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		nodes, err := roam.ReadNodes(db)
+		if err != nil {
+			return err
+		}
+		links, err := roam.ReadLinks(db)
+		if err != nil {
+			return err
+		}
+		files, err := roam.ReadFiles(db)
+		if err != nil {
+			return err
+		}
+		problems := lintRoam(nodes, links, files)
+		switch lintCmdArgs.format {
+		case "alfred":
+			items := []alfred.Item{}
+			for _, p := range problems {
+				items = append(items, alfred.Item{
+					Uid:      p.Kind + ":" + p.Id + ":" + p.Detail,
+					Title:    p.Title,
+					Subtitle: p.Kind + ": " + p.Detail,
+					Arg:      p.Arg(),
+				})
+			}
+			return printJson(alfred.Result{Items: items})
+		case "json":
+			return printJson(problems)
+		case "text":
+			for _, p := range problems {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\t%s\t%s\n", p.Kind, p.Id, p.Title, p.Detail)
+			}
+		default:
+			return fmt.Errorf("unknown format %q", lintCmdArgs.format)
+		}
+		return nil
+	},
+}
+
+type lintProblem struct {
+	Kind   string `json:"kind"`
+	Id     string `json:"id,omitempty"`
+	Title  string `json:"title"`
+	File   string `json:"file"`
+	Detail string `json:"detail"`
+}
+
+// Arg is what opening the problem item should open, the node if there is one or its file.
+func (p lintProblem) Arg() string {
+	if p.Id != "" {
+		return p.Id
+	}
+	return p.File
+}
+
+// linkFiles are files whose level 2 nodes must have a link in the ITEM property, see the chrome,
+// books and elfeed commands.
+var linkFiles = []string{"chrome.org", "books.org", "feeds.org"}
+
+func lintRoam(nodes []roam.Node, links []roam.Link, files []string) (problems []lintProblem) {
+	byId := map[string]*roam.Node{}
+	nodeFiles := map[string]bool{}
+	for i := range nodes {
+		byId[nodes[i].Id] = &nodes[i]
+		nodeFiles[nodes[i].File] = true
+	}
+	backlinks := map[string]int{}
+	for _, l := range links {
+		backlinks[l.Dest]++
+		// Links of missing sources are left over in the database and there's nothing to open.
+		source, found := byId[l.Source]
+		if _, destFound := byId[l.Dest]; !destFound && found {
+			title, _ := source.FullTitle()
+			problems = append(problems, lintProblem{"broken link", source.Id, title, source.File, "link to a missing node " + l.Dest})
+		}
+	}
+	// Duplicates are nodes of the same category with the same own title, regardless of their
+	// outline path and tags.
+	type titleKey struct{ category, title string }
+	titles := map[titleKey][]*roam.Node{}
+	for i := range nodes {
+		n := &nodes[i]
+		title, _ := n.FullTitle()
+		if n.Level == 2 && contains(linkFiles, filepath.Base(n.File)) {
+			if _, err := n.Props.ItemLinkData(); err != nil {
+				problems = append(problems, lintProblem{"bad item link", n.Id, title, n.File, fmt.Sprintf("ITEM %q is not a link", n.Props.Item)})
+			}
+		}
+		if cfg.Excluded(n.Level, n.FileTitle, n.Props) != nil {
+			continue
+		}
+		if backlinks[n.Id] == 0 {
+			problems = append(problems, lintProblem{"orphan", n.Id, title, n.File, "no backlinks"})
+		}
+		key := titleKey{n.Props.Category, n.Title}
+		titles[key] = append(titles[key], n)
+	}
+	for _, dups := range titles {
+		if len(dups) > 1 {
+			for _, n := range dups {
+				title, _ := n.FullTitle()
+				problems = append(problems, lintProblem{"duplicate title", n.Id, title, n.File, fmt.Sprintf("%d nodes share the title", len(dups))})
+			}
+		}
+	}
+	for _, file := range files {
+		if !nodeFiles[file] {
+			problems = append(problems, lintProblem{Kind: "empty file", Title: filepath.Base(file), File: file, Detail: "file has no nodes"})
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Kind != problems[j].Kind {
+			return problems[i].Kind < problems[j].Kind
+		}
+		if problems[i].Title != problems[j].Title {
+			return problems[i].Title < problems[j].Title
+		}
+		return problems[i].Id < problems[j].Id
+	})
+	return problems
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+var lintCmdArgs struct {
+	format string
+}
+
+func init() {
+	roamCmd.AddCommand(lintCmd)
+	lintCmd.Flags().StringVar(&lintCmdArgs.format, "format", "alfred", "Output format: alfred, text or json")
+}
@@ -4,115 +4,124 @@ Copyright © 2023 Peter Solodov <solodov@gmail.com>
 package cmd
 
 import (
-	"database/sql"
 	"fmt"
-	"log"
-	"regexp"
-	"sort"
-	"strconv"
-	"strings"
+	"path/filepath"
+	"sync"
 
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/solodov/org-roam-alfred-items/alfred"
+	"github.com/solodov/org-roam-alfred-items/history"
+	"github.com/solodov/org-roam-alfred-items/match"
 	"github.com/solodov/org-roam-alfred-items/roam"
 	"github.com/spf13/cobra"
 )
 
 var nodesCmd = &cobra.Command{
-	Use:                   "nodes [--category category] [--query regex]",
+	Use:                   "nodes [--category category] [--query query] [--explain id]",
 	DisableFlagsInUseLine: true,
 	Short:                 "Find matching org roam nodes and output them as alfred items",
 	Args:                  cobra.NoArgs,
-	Run: func(cmd *cobra.Command, args []string) {
-		db, err := sql.Open("sqlite3", roamCmdArgs.dbPath)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer db.Close()
-		var titleRe *regexp.Regexp
-		if nodesCmdArgs.query != "" {
-			// TODO: collapse consecutive spaces into one prior to the replacement
-			titleRe = regexp.MustCompile("(?i)" + strings.ReplaceAll(nodesCmdArgs.query, " ", ".*"))
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if nodesCmdArgs.category != "" {
+			if category, err := lookupCategory(nodesCmdArgs.category); category == nil {
+				return err
+			}
 		}
-		rows, err := db.Query(`
-			SELECT nodes.id, nodes.level, nodes.properties, files.title, nodes.title, nodes.olp
-			FROM nodes
-			INNER JOIN files ON nodes.file = files.file`)
+		index, err := loadNodeIndex()
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-		var (
-			level                    int
-			id, fileTitle, nodeTitle string
-			props                    roam.Props
-			olp                      sql.NullString
-			items                    []alfred.Item
-		)
-		scan := func(args ...any) {
-			if err := rows.Scan(args...); err != nil {
-				log.Fatal(err)
+		matcher := match.New(nodesCmdArgs.query)
+		var items []scoredItem
+		for i := range index.Entries {
+			e := &index.Entries[i]
+			reason := hiddenReason(e)
+			m, matched := matcher.MatchKey(e.Title, e.Key, e.Primary)
+			if reason == "" && !matched {
+				reason = fmt.Sprintf("title doesn't match query %q", nodesCmdArgs.query)
 			}
-			for _, a := range args {
-				if v, ok := a.(*string); ok {
-					*v, _ = strconv.Unquote(*v)
-					*v = strings.ReplaceAll(*v, `\"`, `"`)
+			if nodesCmdArgs.explain != "" {
+				if e.Id == nodesCmdArgs.explain {
+					if reason == "" {
+						reason = "visible"
+					}
+					fmt.Fprintf(cmd.OutOrStdout(), "%s: %s\n", e.Title, reason)
+					return nil
 				}
-			}
-		}
-		for rows.Next() {
-			scan(&id, &level, &props, &fileTitle, &nodeTitle, &olp)
-			if nodesCmdArgs.category == "home" && props.Category == "goog" {
-				continue
-			} else if nodesCmdArgs.category == "goog" && props.Category == "home" {
 				continue
 			}
-			if strings.Contains(props.Path, "/drive/") {
+			if reason != "" {
 				continue
 			}
-			if props.Tags.ContainsAnyOf([]string{"ARCHIVE", "feeds", "chrome_link"}) {
-				continue
-			}
-			title := makeNodeTitle(level, props, fileTitle, nodeTitle, olp)
-			if titleRe != nil && !titleRe.MatchString(title) {
-				continue
-			}
-			items = append(items, alfred.Item{Uid: id, Title: title, Arg: id, Subtitle: props.Path})
+			items = append(items, scoredItem{alfred.Item{Uid: e.Id, Title: e.Title, Arg: e.Id, Subtitle: e.File, Save: true}, m.Score})
 		}
-		sort.Slice(items, func(i, j int) bool {
-			return items[i].Title < items[j].Title
-		})
-		printJson(alfred.Result{Items: items})
+		if nodesCmdArgs.explain != "" {
+			return fmt.Errorf("node %v not found", nodesCmdArgs.explain)
+		}
+		addFrecency(items, nodesCmdArgs.query)
+		result := alfred.Result{Items: sortItems(items)}
+		history.FinalizeItems(&result.Items)
+		// Previews and links are added after finalizing so they don't end up in history.
+		addPreviews(result.Items, index, nodesCmdArgs.previews)
+		addLinks(result.Items, index)
+		return printJson(result)
 	},
 }
 
-func makeNodeTitle(level int, props roam.Props, fileTitle, nodeTitle string, nodeOlp sql.NullString) string {
-	var titleBuilder strings.Builder
-	if props.Category != "" {
-		fmt.Fprint(&titleBuilder, props.Category, ": ")
+// nodeIndex keeps the last loaded index in memory, it's reused by the server until the roam
+// database changes.
+var nodeIndex struct {
+	sync.Mutex
+	dbPath string
+	index  *roam.Index
+}
+
+// loadNodeIndex returns the cached node index, rebuilding it if the roam database or exclusion
+// rules changed.
+func loadNodeIndex() (*roam.Index, error) {
+	salt := cfg.ExclusionsFingerprint()
+	stamp, err := roam.IndexStamp(roamCmdArgs.dbPath, salt)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Fprint(&titleBuilder, fileTitle)
-	if level > 0 {
-		fmt.Fprint(&titleBuilder, " > ")
-		if nodeOlp.Valid {
-			for _, groups := range olpRe.FindAllStringSubmatch(nodeOlp.String, -1) {
-				fmt.Fprint(&titleBuilder, groups[1], " > ")
+	nodeIndex.Lock()
+	defer nodeIndex.Unlock()
+	if nodeIndex.index != nil && nodeIndex.dbPath == roamCmdArgs.dbPath && nodeIndex.index.Stamp == stamp {
+		return nodeIndex.index, nil
+	}
+	index, err := roam.LoadIndex(
+		roamCmdArgs.dbPath,
+		filepath.Join(rootCmdArgs.cacheDir, "nodes.gob"),
+		salt,
+		func(n *roam.Node) string {
+			if rule := cfg.Excluded(n.Level, n.FileTitle, n.Props); rule != nil {
+				return "excluded by rule " + rule.String()
 			}
-		}
-		fmt.Fprint(&titleBuilder, nodeTitle)
+			return ""
+		})
+	if err != nil {
+		return nil, err
 	}
-	fmt.Fprint(&titleBuilder, props.Tags)
-	return titleBuilder.String()
+	nodeIndex.dbPath, nodeIndex.index = roamCmdArgs.dbPath, index
+	return index, nil
 }
 
-var nodesCmdArgs struct {
-	category, query string
+// hiddenReason explains why the node is not listed, empty result means the node is visible.
+func hiddenReason(e *roam.IndexEntry) string {
+	if !cfg.Visible(nodesCmdArgs.category, e.Category) {
+		return fmt.Sprintf("category %q is not visible from %q", e.Category, nodesCmdArgs.category)
+	}
+	return e.Hidden
 }
 
-var olpRe *regexp.Regexp
+var nodesCmdArgs struct {
+	category, query, explain string
+	previews                 int
+}
 
 func init() {
 	roamCmd.AddCommand(nodesCmd)
 	nodesCmd.Flags().StringVar(&nodesCmdArgs.category, "category", "", "Category to limit items to")
 	nodesCmd.Flags().StringVar(&nodesCmdArgs.query, "query", "", "Alfred input query")
-	olpRe = regexp.MustCompile(`"((?:\\.|[^"])*)"`)
+	nodesCmd.Flags().IntVar(&nodesCmdArgs.previews, "previews", 20, "Number of top results that get content previews, 0 disables previews")
+	nodesCmd.Flags().StringVar(&nodesCmdArgs.explain, "explain", "", "Print why the node with this ID is hidden instead of listing nodes")
 }
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/solodov/org-roam-alfred-items/emacs"
+	"github.com/solodov/org-roam-alfred-items/roam"
+	"github.com/spf13/cobra"
+)
+
+var openCmd = &cobra.Command{
+	Use:   "open --id id [--new_frame | --other_window]",
+	Short: "Visit the node in Emacs, or in the configured editor if Emacs isn't running",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		var forms []string
+		if openCmdArgs.newFrame {
+			forms = append(forms, "(select-frame (make-frame))")
+		}
+		forms = append(forms,
+			emacs.Call("org-roam-node-visit", emacs.Symbol("node"), openCmdArgs.otherWindow),
+			"(select-frame-set-input-focus (selected-frame))",
+			"t")
+		// The result is nil when Emacs doesn't know the node.
+		expr := fmt.Sprintf("(let ((node %s)) (when node %s))",
+			emacs.Call("org-roam-node-from-id", openCmdArgs.id), strings.Join(forms, " "))
+		out, err := emacs.Default.Eval(ctx, expr)
+		if errors.Is(err, emacs.ErrNotRunning) || errors.Is(err, emacs.ErrNotFound) {
+			log.Printf("%v, opening the node in the editor\n", err)
+			return openInEditor()
+		} else if err != nil {
+			return fmt.Errorf("visiting node failed: %v", err)
+		}
+		if !emacs.ParseBool(out) {
+			return fmt.Errorf("node %v not found", openCmdArgs.id)
+		}
+		return nil
+	},
+}
+
+// openInEditor runs the configured editor at the node position.
+func openInEditor() error {
+	index, err := loadNodeIndex()
+	if err != nil {
+		return err
+	}
+	var entry *roam.IndexEntry
+	for i := range index.Entries {
+		if index.Entries[i].Id == openCmdArgs.id {
+			entry = &index.Entries[i]
+			break
+		}
+	}
+	if entry == nil {
+		return fmt.Errorf("node %v not found", openCmdArgs.id)
+	}
+	line := 1
+	if data, err := os.ReadFile(entry.File); err == nil {
+		line = lineAt(string(data), entry.Pos)
+	}
+	replacer := strings.NewReplacer("{file}", entry.File, "{line}", strconv.Itoa(line))
+	var argv []string
+	for _, arg := range cfg.Editor {
+		argv = append(argv, replacer.Replace(arg))
+	}
+	editor := exec.Command(argv[0], argv[1:]...)
+	if err := editor.Start(); err != nil {
+		return fmt.Errorf("starting editor failed: %v", err)
+	}
+	// The server outlives the editor, waiting for it reaps the process.
+	go editor.Wait()
+	return nil
+}
+
+// lineAt converts a 1-based roam character position into a 1-based line number.
+func lineAt(content string, pos int) int {
+	line, chars := 1, 1
+	for _, c := range content {
+		if chars >= pos {
+			break
+		}
+		if c == '\n' {
+			line++
+		}
+		chars++
+	}
+	return line
+}
+
+var openCmdArgs struct {
+	id                    string
+	newFrame, otherWindow bool
+}
+
+func init() {
+	roamCmd.AddCommand(openCmd)
+	openCmd.Flags().StringVar(&openCmdArgs.id, "id", "", "ID of the node to open")
+	openCmd.Flags().BoolVar(&openCmdArgs.newFrame, "new_frame", false, "Open the node in a new frame")
+	openCmd.Flags().BoolVar(&openCmdArgs.otherWindow, "other_window", false, "Open the node in the other window")
+	openCmd.MarkFlagRequired("id")
+	openCmd.MarkFlagsMutuallyExclusive("new_frame", "other_window")
+}
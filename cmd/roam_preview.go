@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/solodov/org-roam-alfred-items/alfred"
+	"github.com/solodov/org-roam-alfred-items/org"
+	"github.com/solodov/org-roam-alfred-items/roam"
+)
+
+// excerptLength limits the plain-text excerpt shown as large type, in runes.
+const excerptLength = 1500
+
+// preview is a rendered node, page is the path of its HTML page.
+type preview struct {
+	modTime       time.Time
+	excerpt, page string
+}
+
+// previewCache keeps previews by page path with the modification time of the org file they were
+// rendered from, the server reuses them across requests until the file changes.
+var previewCache = map[string]preview{}
+
+// addPreviews sets quicklook URLs and large type excerpts for the first limit items. Items are
+// expected to be nodes with their ID as Uid.
+func addPreviews(items []alfred.Item, index *roam.Index, limit int) {
+	if limit <= 0 {
+		return
+	}
+	entries := map[string]*roam.IndexEntry{}
+	for i := range index.Entries {
+		entries[index.Entries[i].Id] = &index.Entries[i]
+	}
+	files := map[string]string{}
+	for i := range items {
+		if i == limit {
+			break
+		}
+		e, found := entries[items[i].Uid]
+		if !found {
+			continue
+		}
+		source, err := os.Stat(e.File)
+		if err != nil {
+			continue
+		}
+		page := filepath.Join(rootCmdArgs.cacheDir, "previews", url.PathEscape(e.Id)+".html")
+		p, found := previewCache[page]
+		if !found || !p.modTime.Equal(source.ModTime()) {
+			if p, err = readPreview(e, page, source.ModTime(), files); err != nil {
+				continue
+			}
+			previewCache[page] = p
+		}
+		items[i].Text.LargeType = p.excerpt
+		items[i].Quicklookurl = p.page
+	}
+}
+
+// readPreview returns the preview saved in the cache directory unless it's older than the org
+// file, otherwise it renders the node and saves the preview. The excerpt is saved next to the page
+// with the .txt extension.
+func readPreview(e *roam.IndexEntry, page string, modTime time.Time, files map[string]string) (preview, error) {
+	p := preview{modTime: modTime, page: page}
+	text := page[:len(page)-len(".html")] + ".txt"
+	if fresh(page, modTime) && fresh(text, modTime) {
+		if data, err := os.ReadFile(text); err == nil {
+			p.excerpt = string(data)
+			return p, nil
+		}
+	}
+	blocks, err := readNodeBlocks(e, files)
+	if err != nil {
+		return p, err
+	}
+	excerpt := []rune(org.PlainTextBlocks(blocks))
+	if len(excerpt) > excerptLength {
+		excerpt = append(excerpt[:excerptLength], '…')
+	}
+	p.excerpt = string(excerpt)
+	if err := writePreview(e, blocks, page, text, p.excerpt); err != nil {
+		// The excerpt is still shown, but without a page and it's rendered again next time.
+		p.page, p.modTime = "", time.Time{}
+	}
+	return p, nil
+}
+
+// fresh reports whether the file exists and is newer than modTime.
+func fresh(path string, modTime time.Time) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.ModTime().After(modTime)
+}
+
+// readNodeBlocks parses the node subtree from its org file, files caches file contents.
+func readNodeBlocks(e *roam.IndexEntry, files map[string]string) ([]org.Block, error) {
+	content, found := files[e.File]
+	if !found {
+		data, err := os.ReadFile(e.File)
+		if err != nil {
+			return nil, err
+		}
+		content = string(data)
+		files[e.File] = content
+	}
+	return org.Parse(org.Subtree(content, e.Pos)), nil
+}
+
+// writePreview renders the node into an HTML page and saves it with the excerpt in the cache
+// directory.
+func writePreview(e *roam.IndexEntry, blocks []org.Block, page, text, excerpt string) error {
+	if err := os.MkdirAll(filepath.Dir(page), 0700); err != nil {
+		return err
+	}
+	html := org.HTMLPage(e.Title, org.HTML(blocks, org.Options{}))
+	if err := os.WriteFile(page, []byte(html), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(text, []byte(excerpt), 0600)
+}
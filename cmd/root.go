@@ -5,13 +5,22 @@ package cmd
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"os/user"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/solodov/org-roam-alfred-items/alfred"
+	"github.com/solodov/org-roam-alfred-items/config"
+	"github.com/solodov/org-roam-alfred-items/emacs"
 	"github.com/solodov/org-roam-alfred-items/history"
+	"github.com/solodov/org-roam-alfred-items/server"
 	"github.com/spf13/cobra"
 )
 
@@ -20,10 +29,18 @@ var rootCmd = &cobra.Command{
 }
 
 var rootCmdArgs struct {
-	pretty  bool
-	trigger string
+	pretty         bool
+	triggers       []string
+	configPath     string
+	cacheDir       string
+	frecency       bool
+	frecencyPrefix bool
+	incognito      bool
 }
 
+// cfg is loaded before any command runs.
+var cfg *config.Config
+
 var roamCmd = &cobra.Command{
 	Use:   "roam",
 	Short: "Output various nodes from the roam database as Alfred items",
@@ -34,13 +51,26 @@ var roamCmdArgs struct {
 }
 
 func Execute() {
+	if args := os.Args[1:]; forwarded(args) {
+		dir, _ := os.Getwd()
+		resp, err := server.Call(socketPath(), server.Request{Args: args, Env: os.Environ(), Dir: dir})
+		if err == nil {
+			os.Stdout.Write(resp.Stdout)
+			os.Stderr.Write(resp.Stderr)
+			os.Exit(resp.Code)
+		}
+		// Running a command that the server may have run already could repeat its side effects.
+		if !errors.Is(err, server.ErrNotServed) {
+			log.Fatalf("server failed to answer: %v", err)
+		}
+	}
 	err := rootCmd.Execute()
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
-func printJson(data any) {
+func printJson(data any) error {
 	var (
 		result []byte
 		err    error
@@ -51,18 +81,161 @@ func printJson(data any) {
 		result, err = json.Marshal(data)
 	}
 	if err != nil {
-		log.Fatal(err)
-	} else {
-		fmt.Println(string(result))
+		return err
+	}
+	_, err = fmt.Fprintln(rootCmd.OutOrStdout(), string(result))
+	return err
+}
+
+// loadConfig runs before every command. Usage is only printed for errors of arguments, which are
+// reported before it runs.
+func loadConfig(cmd *cobra.Command, args []string) (err error) {
+	cmd.SilenceUsage = true
+	if cfg, err = config.Load(rootCmdArgs.configPath); err != nil {
+		return err
+	}
+	history.Retention = history.PruneOptions{
+		OlderThan:     time.Duration(cfg.History.RetentionDays) * 24 * time.Hour,
+		MaxPerTrigger: cfg.History.MaxPerTrigger,
+	}
+	// The environment is checked here rather than in the flag default because the server changes it
+	// for every request.
+	history.Privacy = history.PrivacyRules{
+		Incognito:   rootCmdArgs.incognito || os.Getenv("alfred_items_incognito") != "",
+		DenyHosts:   cfg.Privacy.DenyHosts,
+		DenyQueries: cfg.Privacy.DenyQueryRegexps(),
+		HashQueries: cfg.Privacy.HashQueries,
+	}
+	return nil
+}
+
+type scoredItem struct {
+	alfred.Item
+	score int
+}
+
+// frecencyWeight converts frecency into match score points, one fresh selection is worth more than
+// a good match. The bonus is capped at maxFrecencyBonus.
+const (
+	frecencyWeight   = 100
+	maxFrecencyBonus = 1 << 20
+)
+
+// addFrecency boosts scores of items that were selected before, see history.Frecency. Scores
+// saturate at math.MaxInt, which exact keyword matches already have.
+func addFrecency(scored []scoredItem, query string) {
+	if !rootCmdArgs.frecency {
+		return
+	}
+	frecency := history.Frecency(triggers(), query, rootCmdArgs.frecencyPrefix)
+	for i := range scored {
+		if f, found := frecency[scored[i].Uid]; found {
+			bonus := maxFrecencyBonus
+			if f*frecencyWeight < maxFrecencyBonus {
+				bonus = int(f * frecencyWeight)
+			}
+			if scored[i].score > math.MaxInt-bonus {
+				scored[i].score = math.MaxInt
+			} else {
+				scored[i].score += bonus
+			}
+		}
+	}
+}
+
+// historySuggestions returns history items matching the query except the items already shown.
+func historySuggestions(query string, shown []alfred.Item) (items []alfred.Item) {
+	known := map[string]bool{}
+	for _, item := range shown {
+		known[history.ItemKey(item)] = true
+	}
+	for _, item := range history.FindMatchingItems(triggers(), query) {
+		if !known[history.StoredKey(item)] {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// sortItems orders items by descending score, ties are broken by title.
+func sortItems(scored []scoredItem) (items []alfred.Item) {
+	sort.SliceStable(scored, func(i, j int) bool {
+		if scored[i].score != scored[j].score {
+			return scored[i].score > scored[j].score
+		}
+		return scored[i].Title < scored[j].Title
+	})
+	for _, s := range scored {
+		items = append(items, s.Item)
+	}
+	return items
+}
+
+// triggers returns triggers of the call with their aliases, the first one is canonical. Without
+// triggers it returns the empty trigger, which is what history is recorded under then.
+func triggers() (triggers []string) {
+	if len(rootCmdArgs.triggers) == 0 {
+		return []string{""}
+	}
+	for _, t := range rootCmdArgs.triggers {
+		for _, alias := range cfg.TriggerGroup(t) {
+			if !contains(triggers, alias) {
+				triggers = append(triggers, alias)
+			}
+		}
+	}
+	return triggers
+}
+
+// selectedTriggers is like triggers for commands that work on all triggers when none are given.
+func selectedTriggers() []string {
+	if len(rootCmdArgs.triggers) == 0 {
+		return nil
+	}
+	return triggers()
+}
+
+// lookupCategory returns the configured category. For unknown categories it outputs an item that
+// explains the problem and returns nil, callers are expected to stop and return the error.
+func lookupCategory(name string) (*config.Category, error) {
+	if c := cfg.Category(name); c != nil {
+		return c, nil
+	}
+	return nil, printJson(alfred.Result{Items: []alfred.Item{{
+		Title:    fmt.Sprintf("unknown category %q", name),
+		Subtitle: "configured categories: " + strings.Join(cfg.CategoryNames(), ", "),
+	}}})
+}
+
+func defaultCacheDir(homeDir string) string {
+	if dir := os.Getenv("alfred_workflow_cache"); dir != "" {
+		return dir
+	}
+	return filepath.Join(homeDir, ".cache/alfred-items")
+}
+
+func defaultDataDir(homeDir string) string {
+	if dir := os.Getenv("alfred_workflow_data"); dir != "" {
+		return dir
 	}
+	return filepath.Join(homeDir, ".local/share/alfred-items")
 }
 
 func init() {
+	rootCmd.PersistentPreRunE = loadConfig
 	u, _ := user.Current()
 	rootCmd.PersistentFlags().BoolVarP(&rootCmdArgs.pretty, "pretty", "p", false, "Pretty-print output")
-	// TODO: this needs to be a list so multiple triggers can be used. The use
-	// case is changing triggers and keeping history.
-	rootCmd.PersistentFlags().StringVarP(&rootCmdArgs.trigger, "trigger", "t", "", "Trigger for this call")
+	rootCmd.PersistentFlags().StringSliceVarP(&rootCmdArgs.triggers, "trigger", "t", nil, "Triggers for this call, history of all of them is used and selections are recorded under the first one")
+	rootCmd.PersistentFlags().BoolVar(&rootCmdArgs.frecency, "frecency", true, "Rank previously selected items higher")
+	rootCmd.PersistentFlags().BoolVar(&rootCmdArgs.frecencyPrefix, "frecency_prefix", false, "Only count selections made with a query sharing a prefix with the current one")
+	rootCmd.PersistentFlags().StringVar(&rootCmdArgs.configPath, "config", filepath.Join(u.HomeDir, ".config/alfred-items/config.json"), "Path to the JSON config file")
+	rootCmd.PersistentFlags().StringVar(&rootCmdArgs.cacheDir, "cache_dir", defaultCacheDir(u.HomeDir), "Directory for caches, Alfred's workflow cache directory by default")
+	envDefault(rootCmd.PersistentFlags(), "cache_dir", func() string { return defaultCacheDir(u.HomeDir) })
+	rootCmd.PersistentFlags().StringVar(&emacs.Default.Path, "emacsclient", "", "Path to emacsclient, looked up in PATH and common locations by default")
+	rootCmd.PersistentFlags().StringVar(&emacs.Default.SocketName, "emacs_socket", "", "Emacs server socket name")
+	rootCmd.PersistentFlags().DurationVar(&emacs.Default.Timeout, "emacs_timeout", emacs.DefaultTimeout, "Timeout of emacsclient calls")
+	rootCmd.PersistentFlags().BoolVar(&rootCmdArgs.incognito, "incognito", false, "Don't record selections in history, also enabled by the alfred_items_incognito variable")
+	rootCmd.PersistentFlags().BoolVar(&history.AnyTerm, "history_any_term", false, "Suggest history items matching any query term rather than all of them")
 	rootCmd.PersistentFlags().StringVar(&history.Path, "history_db_path", filepath.Join(u.HomeDir, ".local/share/alfred-items/history.db"), "Path to the items history database")
 	rootCmd.AddCommand(roamCmd)
 	roamCmd.PersistentFlags().StringVar(&roamCmdArgs.dbPath, "db_path", filepath.Join(u.HomeDir, "org/.roam.db"), "Path to the org roam database")
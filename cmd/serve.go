@@ -0,0 +1,192 @@
+/*
+Copyright © 2023 Peter Solodov <solodov@gmail.com>
+*/
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/solodov/org-roam-alfred-items/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve all other commands over a unix socket, keeping indexes in memory",
+	Long: `Serve all other commands over a unix socket, keeping indexes in memory.
+
+Every other command tries the socket first and runs in-process if no server is listening, the
+output is the same either way. The socket is $alfred_items_socket or server.sock in the cache
+directory. Commands run one at a time in the working directory and environment of the client,
+commands that read standard input always run in-process. When the server is busy for too long the
+client runs the command in-process.`,
+	Args:        cobra.NoArgs,
+	Annotations: map[string]string{inProcessAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := socketPath()
+		log.Printf("listening on %v\n", path)
+		go refreshNodeIndex()
+		return server.Serve(path, handleRequest)
+	},
+}
+
+// inProcessAnnotation marks commands that are never forwarded to the server.
+const inProcessAnnotation = "in_process"
+
+// forwarded reports whether the command line is forwarded to the server.
+func forwarded(args []string) bool {
+	cmd, _, err := rootCmd.Find(args)
+	return err == nil && cmd.Annotations[inProcessAnnotation] == ""
+}
+
+// requestSem serializes requests and background refreshes because commands keep their state in
+// package variables.
+var requestSem = make(chan struct{}, 1)
+
+// busyTimeout is how long a request waits for the previous ones before the client is told to run
+// it in-process.
+const busyTimeout = 2 * time.Second
+
+func acquire(timeout time.Duration) bool {
+	select {
+	case requestSem <- struct{}{}:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func release() {
+	<-requestSem
+}
+
+func socketPath() string {
+	if path := os.Getenv("alfred_items_socket"); path != "" {
+		return path
+	}
+	u, _ := user.Current()
+	return filepath.Join(defaultCacheDir(u.HomeDir), "server.sock")
+}
+
+// handleRequest runs the command in the client environment and working directory with output
+// captured. Standard input is empty.
+func handleRequest(req server.Request) (resp server.Response) {
+	if !acquire(busyTimeout) {
+		resp.Busy = true
+		return resp
+	}
+	defer release()
+	if !forwarded(req.Args) {
+		resp.Code, resp.Stderr = 1, []byte("command must run in-process\n")
+		return resp
+	}
+	env := os.Environ()
+	setEnv(req.Env)
+	defer setEnv(env)
+	if req.Dir != "" {
+		if dir, err := os.Getwd(); err == nil {
+			defer os.Chdir(dir)
+		}
+		if err := os.Chdir(req.Dir); err != nil {
+			resp.Code, resp.Stderr = 1, []byte(err.Error()+"\n")
+			return resp
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	rootCmd.SetIn(&bytes.Buffer{})
+	rootCmd.SetOut(&stdout)
+	rootCmd.SetErr(&stderr)
+	log.SetOutput(&stderr)
+	defer func() {
+		rootCmd.SetIn(nil)
+		rootCmd.SetOut(nil)
+		rootCmd.SetErr(nil)
+		log.SetOutput(os.Stderr)
+	}()
+	resetFlags(rootCmd)
+	rootCmd.SetArgs(req.Args)
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				fmt.Fprintf(&stderr, "panic: %v\n", r)
+				resp.Code = 1
+			}
+		}()
+		if err := rootCmd.Execute(); err != nil {
+			resp.Code = 1
+		}
+	}()
+	resp.Stdout, resp.Stderr = stdout.Bytes(), stderr.Bytes()
+	return resp
+}
+
+func setEnv(env []string) {
+	os.Clearenv()
+	for _, kv := range env {
+		if k, v, found := strings.Cut(kv, "="); found {
+			os.Setenv(k, v)
+		}
+	}
+}
+
+// envDefaults compute defaults of flags that depend on the environment. The server recomputes them
+// in the client environment of every request, the defaults registered in init are the server's.
+var envDefaults = map[*pflag.Flag]func() string{}
+
+// envDefault registers the function that computes the default of the named flag.
+func envDefault(flags *pflag.FlagSet, name string, fn func() string) {
+	envDefaults[flags.Lookup(name)] = fn
+}
+
+// resetFlags restores default values of all flags so values from a previous request don't leak
+// into the next one.
+func resetFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if fn, found := envDefaults[f]; found {
+			f.DefValue = fn()
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			sv.Replace(nil)
+			if f.DefValue != "[]" {
+				sv.Replace(strings.Split(strings.Trim(f.DefValue, "[]"), ","))
+			}
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, sub := range cmd.Commands() {
+		resetFlags(sub)
+	}
+}
+
+// refreshNodeIndex rebuilds the node index in the background when the roam database changes so
+// the first query after a change doesn't have to wait. It starts after the first nodes request and
+// uses flag values of the last request.
+func refreshNodeIndex() {
+	for range time.Tick(5 * time.Second) {
+		if !acquire(time.Second) {
+			continue
+		}
+		if nodeIndex.index != nil {
+			if _, err := loadNodeIndex(); err != nil {
+				log.Printf("node index refresh failed: %v\n", err)
+			}
+		}
+		release()
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+}
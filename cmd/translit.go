@@ -97,7 +97,7 @@ func translitConversionTable() []fromToPair {
 var translitCmd = &cobra.Command{
 	Use:   "translit",
 	Short: "Convert Latin-transliterated Russian into Cyrillic Russian",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		var b strings.Builder
 		table := translitConversionTable()
 		for _, arg := range args {
@@ -116,7 +116,7 @@ var translitCmd = &cobra.Command{
 			}
 		}
 		translation := b.String()
-		printJson(alfred.Result{Items: []alfred.Item{alfred.Item{
+		return printJson(alfred.Result{Items: []alfred.Item{alfred.Item{
 			Title: translation,
 			Text:  alfred.Text{Copy: translation, LargeType: translation},
 			Arg:   translation,
@@ -0,0 +1,361 @@
+/*
+Copyright © 2023 Peter Solodov <solodov@gmail.com>
+*/
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Placeholder is replaced with the encoded query in search engine and keyword URL templates,
+// PathPlaceholder with the query always encoded as a path segment, so spaces become %20 rather
+// than +.
+const (
+	Placeholder     = "{query}"
+	PathPlaceholder = "{query_path}"
+)
+
+type Config struct {
+	Categories    []Category      `json:"categories"`
+	SearchEngines []SearchEngine  `json:"search_engines"`
+	Exclusions    []ExclusionRule `json:"exclusions"`
+	// Editor is the command that opens nodes when Emacs isn't running, {file} and {line} are
+	// replaced in every argument.
+	Editor []string `json:"editor"`
+	// PublishUrl is the URL of a published node used in markdown and HTML links, {id} is replaced
+	// with the node ID.
+	PublishUrl string  `json:"publish_url"`
+	History    History `json:"history"`
+	Privacy    Privacy `json:"privacy"`
+	// TriggerAliases groups triggers that share history, the first trigger of a group is the
+	// canonical one that selections are recorded under.
+	TriggerAliases [][]string `json:"trigger_aliases"`
+}
+
+// Privacy limits what is recorded in history.
+type Privacy struct {
+	// DenyHosts are hosts of item URLs that are never recorded, subdomains included.
+	DenyHosts []string `json:"deny_hosts,omitempty"`
+	// DenyQueries are regexps of queries whose selections are never recorded.
+	DenyQueries []string `json:"deny_queries,omitempty"`
+	// HashQueries records hashes of query words instead of queries, items made of the query aren't
+	// recorded then. Other fields of recorded items stay in plaintext.
+	HashQueries bool `json:"hash_queries,omitempty"`
+
+	denyQueryRes []*regexp.Regexp
+}
+
+// DenyQueryRegexps returns compiled DenyQueries.
+func (p *Privacy) DenyQueryRegexps() []*regexp.Regexp {
+	return p.denyQueryRes
+}
+
+// History configures retention of the selection history, applied whenever an item is added.
+type History struct {
+	// RetentionDays removes items that weren't used for this many days, zero keeps all.
+	RetentionDays int `json:"retention_days"`
+	// MaxPerTrigger keeps only this many most recently used items of each trigger, zero keeps all.
+	MaxPerTrigger int `json:"max_per_trigger"`
+}
+
+type Category struct {
+	Name string `json:"name"`
+	// Includes lists other categories whose nodes and links are visible in this one.
+	Includes []string `json:"includes,omitempty"`
+	// Profile is the browser profile for links opened in this category, defaults to the name.
+	Profile string `json:"profile,omitempty"`
+	// SearchEngines lists names of engines used in this category in addition to engines that list
+	// the category themselves.
+	SearchEngines    []string          `json:"search_engines,omitempty"`
+	CaptureTemplates []CaptureTemplate `json:"capture_templates,omitempty"`
+}
+
+type CaptureTemplate struct {
+	// Key is the org capture template key.
+	Key string `json:"key"`
+	// ImmediateKey is the template used when alfred asks for immediate finish by prefixing the key
+	// with "i", it defaults to the prefixed key. Templates that are always edited set it to Key.
+	ImmediateKey string `json:"immediate_key,omitempty"`
+	// BodySuffix is appended to the query in the captured body, like empty lines that separate it
+	// from the rest of the template.
+	BodySuffix string `json:"body_suffix,omitempty"`
+	// Title of the capture item, {browser} is replaced with the quoted browser tab title or URL.
+	Title string `json:"title"`
+	// Context is empty if the template is always offered, "clocked_in" if it requires a clocked-in
+	// task and "browser" if it requires browser state.
+	Context string `json:"context,omitempty"`
+	// NeedsQuery makes the item invalid until something is typed.
+	NeedsQuery bool `json:"needs_query,omitempty"`
+}
+
+type SearchEngine struct {
+	Name string `json:"name"`
+	// Title is the beginning of the item title, the quoted query is appended to it. Defaults to
+	// "search <name> for".
+	Title string `json:"title,omitempty"`
+	// Url is a template that must contain the query placeholder.
+	Url string `json:"url"`
+	// Encoding is either "query" (the default) or "path", it selects how the query is escaped
+	// before substitution.
+	Encoding   string   `json:"encoding,omitempty"`
+	Icon       string   `json:"icon,omitempty"`
+	Categories []string `json:"categories"`
+	// Keyword, when set, limits dynamic items to this engine if the query starts with it.
+	Keyword string `json:"keyword,omitempty"`
+}
+
+func (e SearchEngine) Expand(query string) string {
+	return ExpandUrl(e.Url, e.Encoding, query)
+}
+
+// ExpandUrl substitutes the escaped query for placeholders in the URL template. Encoding of
+// Placeholder is either "query" or "path", empty encoding means "query".
+func ExpandUrl(template, encoding, query string) string {
+	escaped := url.QueryEscape(query)
+	if encoding == "path" {
+		escaped = url.PathEscape(query)
+	}
+	template = strings.ReplaceAll(template, PathPlaceholder, url.PathEscape(query))
+	return strings.ReplaceAll(template, Placeholder, escaped)
+}
+
+func (e SearchEngine) ItemTitle(query string) string {
+	title := e.Title
+	if title == "" {
+		title = "search " + e.Name + " for"
+	}
+	return fmt.Sprintf(`%s "%v"`, title, query)
+}
+
+func (e SearchEngine) InCategory(category string) bool {
+	return contains(e.Categories, category)
+}
+
+func (c Category) Sees(category string) bool {
+	if category == c.Name {
+		return true
+	}
+	for _, name := range c.Includes {
+		if name == category {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Category) BrowserProfile() string {
+	if c.Profile != "" {
+		return c.Profile
+	}
+	return c.Name
+}
+
+// Immediate returns the template key for immediate finish.
+func (t CaptureTemplate) Immediate() string {
+	if t.ImmediateKey != "" {
+		return t.ImmediateKey
+	}
+	return "i" + t.Key
+}
+
+// CaptureTemplate returns the template of the category that arg, a key optionally prefixed with
+// "i" for immediate finish, refers to, and whether immediate finish was asked for.
+func (c Category) CaptureTemplate(arg string) (t *CaptureTemplate, immediate bool) {
+	for i := range c.CaptureTemplates {
+		if c.CaptureTemplates[i].Key == arg {
+			return &c.CaptureTemplates[i], false
+		}
+	}
+	for i := range c.CaptureTemplates {
+		if "i"+c.CaptureTemplates[i].Key == arg {
+			return &c.CaptureTemplates[i], true
+		}
+	}
+	return nil, false
+}
+
+// Category returns the named category or nil if it's not configured.
+func (c *Config) Category(name string) *Category {
+	for i := range c.Categories {
+		if c.Categories[i].Name == name {
+			return &c.Categories[i]
+		}
+	}
+	return nil
+}
+
+// SearchEngine returns the named search engine or nil if it's not configured.
+func (c *Config) SearchEngine(name string) *SearchEngine {
+	for i := range c.SearchEngines {
+		if c.SearchEngines[i].Name == name {
+			return &c.SearchEngines[i]
+		}
+	}
+	return nil
+}
+
+// TriggerGroup returns the alias group of the trigger, or just the trigger if it has no aliases.
+func (c *Config) TriggerGroup(trigger string) []string {
+	for _, group := range c.TriggerAliases {
+		if contains(group, trigger) {
+			return group
+		}
+	}
+	return []string{trigger}
+}
+
+func (c *Config) CategoryNames() (names []string) {
+	for _, category := range c.Categories {
+		names = append(names, category.Name)
+	}
+	return names
+}
+
+// Visible reports whether a node of the given category is visible from category from. Nodes of
+// the same or included categories are visible, nodes of other configured categories are hidden and
+// nodes without a category or with an unknown one are visible everywhere. Empty from sees
+// everything.
+func (c *Config) Visible(from, category string) bool {
+	if from == "" {
+		return true
+	}
+	if cat := c.Category(from); cat != nil && cat.Sees(category) {
+		return true
+	}
+	return c.Category(category) == nil
+}
+
+// EnginesFor returns search engines that belong to the category, in the configured order.
+func (c *Config) EnginesFor(category string) (engines []SearchEngine) {
+	cat := c.Category(category)
+	for _, e := range c.SearchEngines {
+		if e.InCategory(category) || (cat != nil && contains(cat.SearchEngines, e.Name)) {
+			engines = append(engines, e)
+		}
+	}
+	return engines
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Load reads configuration from path on top of the defaults. Top-level keys present in the file
+// replace the corresponding defaults entirely, a missing file means defaults are used as is.
+func Load(path string) (*Config, error) {
+	c := Default()
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := clearPresent(c, data); err != nil {
+			return nil, fmt.Errorf("invalid config %v: %v", path, err)
+		}
+		if err := json.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("invalid config %v: %v", path, err)
+		}
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %v: %v", path, err)
+	}
+	return c, nil
+}
+
+// clearPresent zeroes fields of c whose keys are present in data. JSON decoding into a slice reuses
+// its elements, so without this fields missing from an entry of the file would keep default values.
+func clearPresent(c *Config, data []byte) error {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		if _, found := keys[name]; found && name != "" && name != "-" {
+			v.Field(i).Set(reflect.Zero(v.Field(i).Type()))
+		}
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	if len(c.Editor) == 0 || c.Editor[0] == "" {
+		return fmt.Errorf("editor command is empty")
+	}
+	if c.History.RetentionDays < 0 || c.History.MaxPerTrigger < 0 {
+		return fmt.Errorf("history retention can't be negative")
+	}
+	for _, expr := range c.Privacy.DenyQueries {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid deny query %q: %v", expr, err)
+		}
+		c.Privacy.denyQueryRes = append(c.Privacy.denyQueryRes, re)
+	}
+	aliased := map[string]bool{}
+	for _, group := range c.TriggerAliases {
+		if len(group) == 0 {
+			return fmt.Errorf("empty trigger alias group")
+		}
+		for _, t := range group {
+			if aliased[t] {
+				return fmt.Errorf("trigger %q is in several alias groups", t)
+			}
+			aliased[t] = true
+		}
+	}
+	for i := range c.Exclusions {
+		if err := c.Exclusions[i].compile(); err != nil {
+			return err
+		}
+	}
+	seen := map[string]bool{}
+	for _, cat := range c.Categories {
+		if cat.Name == "" {
+			return fmt.Errorf("category without a name")
+		}
+		if seen[cat.Name] {
+			return fmt.Errorf("duplicate category %v", cat.Name)
+		}
+		seen[cat.Name] = true
+		keys := map[string]bool{}
+		for _, t := range cat.CaptureTemplates {
+			if t.Key == "" {
+				return fmt.Errorf("category %v: capture template without a key", cat.Name)
+			}
+			if keys[t.Key] {
+				return fmt.Errorf("category %v: duplicate capture template %v", cat.Name, t.Key)
+			}
+			keys[t.Key] = true
+			if t.Context != "" && t.Context != "clocked_in" && t.Context != "browser" {
+				return fmt.Errorf("category %v: capture template %v has unknown context %q", cat.Name, t.Key, t.Context)
+			}
+		}
+	}
+	for _, e := range c.SearchEngines {
+		if e.Name == "" {
+			return fmt.Errorf("search engine without a name")
+		}
+		if !strings.Contains(e.Url, Placeholder) && !strings.Contains(e.Url, PathPlaceholder) {
+			return fmt.Errorf("search engine %v: url %q has no %v or %v placeholder", e.Name, e.Url, Placeholder, PathPlaceholder)
+		}
+		if e.Encoding != "" && e.Encoding != "query" && e.Encoding != "path" {
+			return fmt.Errorf("search engine %v: unknown encoding %q", e.Name, e.Encoding)
+		}
+	}
+	return nil
+}
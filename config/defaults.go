@@ -0,0 +1,102 @@
+package config
+
+// Default returns configuration that is used when there is no config file.
+func Default() *Config {
+	return &Config{
+		Categories: []Category{
+			{
+				Name: "home",
+				CaptureTemplates: []CaptureTemplate{
+					{Key: "h", Title: "capture note into inbox", NeedsQuery: true},
+					{Key: "c", Title: "capture note for the clocked-in task", Context: "clocked_in", NeedsQuery: true, BodySuffix: "\n\n"},
+					{Key: "bh", ImmediateKey: "yh", Title: "capture {browser} into inbox", Context: "browser", BodySuffix: "\n\n"},
+				},
+			},
+			{
+				Name: "goog",
+				CaptureTemplates: []CaptureTemplate{
+					{Key: "g", Title: "capture note into inbox", NeedsQuery: true},
+					{Key: "c", Title: "capture note for the clocked-in task", Context: "clocked_in", NeedsQuery: true, BodySuffix: "\n\n"},
+					{Key: "bg", ImmediateKey: "yg", Title: "capture {browser} into inbox", Context: "browser", BodySuffix: "\n\n"},
+					{Key: "bd", ImmediateKey: "yd", Title: "capture {browser} for ads doc review", Context: "browser", BodySuffix: "\n\n"},
+					{Key: "bf", ImmediateKey: "yf", Title: "capture {browser} for ads fact", Context: "browser", BodySuffix: "\n\n"},
+					{Key: "bc", ImmediateKey: "yc", Title: "capture {browser} for career reading", Context: "browser", BodySuffix: "\n\n"},
+					{Key: "f", Title: "capture ads fact"},
+				},
+			},
+		},
+		SearchEngines: []SearchEngine{
+			{
+				Name:       "google",
+				Url:        "https://www.google.com/search?q={query}",
+				Icon:       "chrome",
+				Categories: []string{"home"},
+			},
+			{
+				Name:       "map",
+				Url:        "https://www.google.com/maps/search/{query}",
+				Encoding:   "path",
+				Icon:       "map",
+				Categories: []string{"home"},
+			},
+			{
+				Name:       "youtube",
+				Url:        "https://www.youtube.com/results?search_query={query}",
+				Icon:       "youtube",
+				Categories: []string{"home"},
+			},
+			{
+				Name:       "moma",
+				Url:        "https://moma.corp.google.com/search?q={query}",
+				Icon:       "moma",
+				Categories: []string{"goog"},
+			},
+			{
+				Name:       "cs",
+				Title:      "code search for",
+				Url:        "https://source.corp.google.com/search?q={query}",
+				Icon:       "cs",
+				Categories: []string{"goog"},
+			},
+			{
+				Name:       "google_corp",
+				Title:      "search google for",
+				Url:        "https://www.google.com/search?q={query}",
+				Icon:       "search",
+				Categories: []string{"goog"},
+			},
+			{
+				Name:       "glossary",
+				Url:        "https://moma.corp.google.com/search?hq=type:glossary&q={query}",
+				Icon:       "glossary",
+				Categories: []string{"goog"},
+			},
+			{
+				Name:       "who",
+				Url:        "https://moma.corp.google.com/search?hq=type:people&q={query}",
+				Icon:       "who",
+				Categories: []string{"goog"},
+			},
+			{
+				Name:       "go links",
+				Url:        "https://moma.corp.google.com/go2/search?q={query}",
+				Icon:       "go_links",
+				Categories: []string{"goog"},
+			},
+			// Used by the books command.
+			{
+				Name: "goodreads",
+				Url:  "https://www.goodreads.com/search?q={query}",
+			},
+		},
+		PublishUrl: "org-protocol://roam-node?node={id}",
+		History:    History{RetentionDays: 730, MaxPerTrigger: 2000},
+		Editor:     []string{"open", "-a", "Emacs", "--args", "+{line}", "{file}"},
+		Exclusions: []ExclusionRule{
+			{Name: "drive", Path: "*/drive/*"},
+			{Name: "archived", Tags: []string{"ARCHIVE"}},
+			{Name: "feeds", Tags: []string{"feeds"}},
+			{Name: "chrome links", Tags: []string{"chrome_link"}},
+		},
+	}
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/solodov/org-roam-alfred-items/roam"
+)
+
+// ExclusionRule hides matching nodes from node listings. All conditions that are set must match
+// for the rule to apply. Glob patterns support * (any sequence, including slashes) and ? (any
+// character) and match the whole value.
+type ExclusionRule struct {
+	// Name identifies the rule in explanations.
+	Name string `json:"name"`
+	// Path is a glob matched against the node file path.
+	Path string `json:"path,omitempty"`
+	// FileTitle is a glob matched against the title of the node file.
+	FileTitle string `json:"file_title,omitempty"`
+	// Tags match if the node has any of them, inherited tags included.
+	Tags []string `json:"tags,omitempty"`
+	// Level matches the node level exactly, 0 is the file node.
+	Level *int `json:"level,omitempty"`
+	// Category matches the node category exactly.
+	Category string `json:"category,omitempty"`
+	// Properties maps property names to globs, "*" means the property only has to be present.
+	Properties map[string]string `json:"properties,omitempty"`
+
+	pathRe, fileTitleRe *regexp.Regexp
+	propertyRes         map[string]*regexp.Regexp
+}
+
+func (r *ExclusionRule) compile() (err error) {
+	if r.Name == "" {
+		return fmt.Errorf("exclusion rule without a name")
+	}
+	// A rule without conditions would hide every node.
+	if r.Path == "" && r.FileTitle == "" && len(r.Tags) == 0 && r.Level == nil && r.Category == "" && len(r.Properties) == 0 {
+		return fmt.Errorf("exclusion rule %q has no conditions", r.Name)
+	}
+	if r.Path != "" {
+		r.pathRe = globRegexp(r.Path)
+	}
+	if r.FileTitle != "" {
+		r.fileTitleRe = globRegexp(r.FileTitle)
+	}
+	r.propertyRes = map[string]*regexp.Regexp{}
+	for name, glob := range r.Properties {
+		r.propertyRes[name] = globRegexp(glob)
+	}
+	return nil
+}
+
+func (r *ExclusionRule) Matches(level int, fileTitle string, props roam.Props) bool {
+	if r.pathRe != nil && !r.pathRe.MatchString(props.Path) {
+		return false
+	}
+	if r.fileTitleRe != nil && !r.fileTitleRe.MatchString(fileTitle) {
+		return false
+	}
+	if len(r.Tags) > 0 && !props.Tags.ContainsAnyOf(r.Tags) {
+		return false
+	}
+	if r.Level != nil && *r.Level != level {
+		return false
+	}
+	if r.Category != "" && r.Category != props.Category {
+		return false
+	}
+	for name, re := range r.propertyRes {
+		if val, found := props.All[name]; !found || !re.MatchString(val) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *ExclusionRule) String() string {
+	var conds []string
+	if r.Path != "" {
+		conds = append(conds, fmt.Sprintf("path %q", r.Path))
+	}
+	if r.FileTitle != "" {
+		conds = append(conds, fmt.Sprintf("file title %q", r.FileTitle))
+	}
+	if len(r.Tags) > 0 {
+		conds = append(conds, "tags "+strings.Join(r.Tags, "|"))
+	}
+	if r.Level != nil {
+		conds = append(conds, fmt.Sprintf("level %d", *r.Level))
+	}
+	if r.Category != "" {
+		conds = append(conds, fmt.Sprintf("category %q", r.Category))
+	}
+	names := make([]string, 0, len(r.Properties))
+	for name := range r.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		conds = append(conds, fmt.Sprintf("property %v %q", name, r.Properties[name]))
+	}
+	return fmt.Sprintf("%q (%s)", r.Name, strings.Join(conds, ", "))
+}
+
+// Excluded returns the first rule that matches the node or nil if the node isn't excluded.
+func (c *Config) Excluded(level int, fileTitle string, props roam.Props) *ExclusionRule {
+	for i := range c.Exclusions {
+		if c.Exclusions[i].Matches(level, fileTitle, props) {
+			return &c.Exclusions[i]
+		}
+	}
+	return nil
+}
+
+// ExclusionsFingerprint changes whenever exclusion rules change, it's used to invalidate caches
+// that store exclusion results.
+func (c *Config) ExclusionsFingerprint() string {
+	data, _ := json.Marshal(c.Exclusions)
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+func globRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
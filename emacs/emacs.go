@@ -0,0 +1,220 @@
+/*
+Copyright © 2023 Peter Solodov <solodov@gmail.com>
+*/
+package emacs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotRunning means emacsclient couldn't connect to an Emacs server.
+	ErrNotRunning = errors.New("emacs is not running")
+	ErrNotFound   = errors.New("emacsclient not found")
+	ErrTimeout    = errors.New("emacs didn't respond in time")
+)
+
+// DefaultTimeout bounds every emacsclient call so a busy or hung Emacs doesn't block Alfred.
+const DefaultTimeout = 2 * time.Second
+
+// knownPaths are checked when emacsclient isn't in PATH, which is common for processes started by
+// Alfred.
+var knownPaths = []string{
+	"/opt/homebrew/bin/emacsclient",
+	"/usr/local/bin/emacsclient",
+	"/Applications/Emacs.app/Contents/MacOS/bin/emacsclient",
+	"/run/current-system/sw/bin/emacsclient",
+}
+
+type Client struct {
+	// Path to emacsclient, it's looked up in PATH and known locations when empty.
+	Path string
+	// SocketName is passed to emacsclient when set.
+	SocketName string
+	// Timeout of each call, DefaultTimeout when zero.
+	Timeout time.Duration
+}
+
+// Default is the client used by commands, it's configured from flags.
+var Default = &Client{}
+
+func (c *Client) path() (string, error) {
+	if c.Path != "" {
+		return c.Path, nil
+	}
+	if path, err := exec.LookPath("emacsclient"); err == nil {
+		return path, nil
+	}
+	for _, path := range knownPaths {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+// Run calls emacsclient with the arguments and returns its standard output.
+func (c *Client) Run(ctx context.Context, args ...string) (string, error) {
+	path, err := c.path()
+	if err != nil {
+		return "", err
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if c.SocketName != "" {
+		args = append([]string{"--socket-name", c.SocketName}, args...)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	// Children left behind by a killed emacsclient mustn't keep the call waiting for its output.
+	cmd.WaitDelay = 100 * time.Millisecond
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", ErrTimeout
+		}
+		msg := stderr.String()
+		for _, marker := range notRunningMarkers {
+			if strings.Contains(msg, marker) {
+				return "", ErrNotRunning
+			}
+		}
+		return "", fmt.Errorf("emacsclient failed: %v: %s", err, strings.TrimSpace(msg))
+	}
+	return stdout.String(), nil
+}
+
+// notRunningMarkers are parts of emacsclient error messages printed when there is no server.
+var notRunningMarkers = []string{
+	"can't find socket",
+	"No socket or alternate editor",
+	"connect: Connection refused",
+	"connect: No such file or directory",
+	"error accessing socket",
+}
+
+// Eval evaluates the expression and returns the printed result.
+func (c *Client) Eval(ctx context.Context, expr string) (string, error) {
+	out, err := c.Run(ctx, "--eval", expr)
+	return strings.TrimRight(out, "\n"), err
+}
+
+// Open passes the argument, like an org-protocol URL, to Emacs without waiting for it.
+func (c *Client) Open(ctx context.Context, arg string) error {
+	_, err := c.Run(ctx, "--no-wait", arg)
+	return err
+}
+
+// Symbol is inserted into generated elisp as is, all other strings are quoted.
+type Symbol string
+
+// Quote returns s as an elisp string literal.
+func Quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// Call builds a function call form. Strings are quoted, booleans become t or nil, symbols and
+// numbers are inserted as is.
+func Call(fn string, args ...any) string {
+	var b strings.Builder
+	b.WriteString("(" + fn)
+	for _, arg := range args {
+		b.WriteString(" ")
+		switch v := arg.(type) {
+		case Symbol:
+			b.WriteString(string(v))
+		case string:
+			b.WriteString(Quote(v))
+		case bool:
+			if v {
+				b.WriteString("t")
+			} else {
+				b.WriteString("nil")
+			}
+		default:
+			fmt.Fprint(&b, v)
+		}
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// ParseString decodes a printed elisp string, nil becomes an empty string. Escapes are the ones
+// the printer produces: quoted characters, \n and friends, octal and hex codes and ignored escaped
+// newlines and spaces.
+func ParseString(printed string) (string, error) {
+	printed = strings.TrimSpace(printed)
+	if printed == "nil" {
+		return "", nil
+	}
+	if len(printed) < 2 || printed[0] != '"' || printed[len(printed)-1] != '"' {
+		return "", fmt.Errorf("not a printed string: %q", printed)
+	}
+	body := printed[1 : len(printed)-1]
+	var b strings.Builder
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if i++; i == len(body) {
+			return "", fmt.Errorf("unterminated escape in %q", printed)
+		}
+		switch c = body[i]; {
+		case c >= '0' && c <= '7':
+			// Up to three octal digits.
+			n, j := 0, i
+			for ; j < len(body) && j < i+3 && body[j] >= '0' && body[j] <= '7'; j++ {
+				n = n*8 + int(body[j]-'0')
+			}
+			if n > 0xff {
+				return "", fmt.Errorf("invalid octal escape in %q", printed)
+			}
+			b.WriteByte(byte(n))
+			i = j - 1
+		case c == 'x':
+			j := i + 1
+			for j < len(body) && strings.IndexByte("0123456789abcdefABCDEF", body[j]) >= 0 {
+				j++
+			}
+			n, err := strconv.ParseUint(body[i+1:j], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid hex escape in %q", printed)
+			}
+			b.WriteRune(rune(n))
+			i = j - 1
+		case c == '\n' || c == ' ':
+			// Escaped newlines are ignored by the reader, an escaped space ends a hex escape.
+		default:
+			if r, found := simpleEscapes[c]; found {
+				b.WriteByte(r)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+// simpleEscapes are escapes of control characters, other escaped characters stand for themselves.
+var simpleEscapes = map[byte]byte{
+	'a': '\a', 'b': '\b', 'd': 0x7f, 'e': 0x1b, 'f': '\f', 'n': '\n', 'r': '\r', 's': ' ', 't': '\t', 'v': '\v',
+}
+
+// ParseBool treats nil as false and any other value as true, like elisp does.
+func ParseBool(printed string) bool {
+	return strings.TrimSpace(printed) != "nil"
+}
@@ -0,0 +1,138 @@
+package emacs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeEmacsclient is put on PATH as emacsclient. It records its arguments and behaves according
+// to FAKE_EMACS_MODE.
+const fakeEmacsclient = `#!/bin/sh
+printf '%s\n' "$@" > "$FAKE_EMACS_ARGS"
+case "$FAKE_EMACS_MODE" in
+ok) printf '%s\n' "$FAKE_EMACS_OUTPUT" ;;
+down) echo "emacsclient: can't find socket; have you started the server?" >&2; exit 1 ;;
+fail) echo "*ERROR*: Symbol's function definition is void: foo" >&2; exit 1 ;;
+hang) sleep 5 ;;
+esac
+`
+
+// setupFake installs the fake emacsclient and returns the file its arguments are written to.
+func setupFake(t *testing.T, mode, output string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "emacsclient"), []byte(fakeEmacsclient), 0755); err != nil {
+		t.Fatal(err)
+	}
+	args := filepath.Join(dir, "args")
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_EMACS_MODE", mode)
+	t.Setenv("FAKE_EMACS_OUTPUT", output)
+	t.Setenv("FAKE_EMACS_ARGS", args)
+	return args
+}
+
+func TestEval(t *testing.T) {
+	argsPath := setupFake(t, "ok", `"clocked \"in\""`)
+	c := &Client{SocketName: "work"}
+	out, err := c.Eval(context.Background(), Call("message", "a \"b\" \\c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != `"clocked \"in\""` {
+		t.Errorf("Eval() = %q", out)
+	}
+	if s, err := ParseString(out); err != nil || s != `clocked "in"` {
+		t.Errorf("ParseString(%q) = %q, %v", out, s, err)
+	}
+	data, err := os.ReadFile(argsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--socket-name\nwork\n--eval\n" + `(message "a \"b\" \\c")` + "\n"
+	if string(data) != want {
+		t.Errorf("emacsclient args = %q, want %q", data, want)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	for _, tc := range []struct {
+		mode    string
+		timeout time.Duration
+		want    error
+	}{
+		{"down", 0, ErrNotRunning},
+		{"hang", 100 * time.Millisecond, ErrTimeout},
+	} {
+		setupFake(t, tc.mode, "")
+		c := &Client{Timeout: tc.timeout}
+		if _, err := c.Eval(context.Background(), "t"); !errors.Is(err, tc.want) {
+			t.Errorf("%v: Eval() error = %v, want %v", tc.mode, err, tc.want)
+		}
+	}
+	setupFake(t, "fail", "")
+	_, err := (&Client{}).Eval(context.Background(), "(foo)")
+	if err == nil || errors.Is(err, ErrNotRunning) || !strings.Contains(err.Error(), "void: foo") {
+		t.Errorf("fail: Eval() error = %v", err)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	c := &Client{}
+	saved := knownPaths
+	knownPaths = nil
+	defer func() { knownPaths = saved }()
+	if _, err := c.Eval(context.Background(), "t"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Eval() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestCall(t *testing.T) {
+	got := Call("f", "x\"y", Symbol("node"), true, false, 3)
+	if want := `(f "x\"y" node t nil 3)`; got != want {
+		t.Errorf("Call() = %q, want %q", got, want)
+	}
+}
+
+func TestParseString(t *testing.T) {
+	for _, tc := range []struct {
+		printed, want string
+	}{
+		{`nil`, ""},
+		{`""`, ""},
+		{`"plain"`, "plain"},
+		{`"quote \" and backslash \\"`, `quote " and backslash \`},
+		{`"line\nbreak\ttab"`, "line\nbreak\ttab"},
+		{`"octal \101\0\177 end"`, "octal A\x00\x7f end"},
+		{`"\3777"`, "\xff7"},
+		{`"hex \x41\ B"`, "hex AB"},
+		{`"hex \x43a"`, "hex к"},
+		{"\"escaped \\\nnewline\"", "escaped newline"},
+		{`"\s\e"`, " \x1b"},
+		{`"кириллица"`, "кириллица"},
+	} {
+		got, err := ParseString(tc.printed)
+		if err != nil || got != tc.want {
+			t.Errorf("ParseString(%q) = %q, %v, want %q", tc.printed, got, err, tc.want)
+		}
+	}
+	for _, printed := range []string{`t`, `"unterminated`, `"trailing \"`, `"\400"`, `"\xzz"`} {
+		if got, err := ParseString(printed); err == nil {
+			t.Errorf("ParseString(%q) = %q, want an error", printed, got)
+		}
+	}
+}
+
+func TestParseBool(t *testing.T) {
+	for printed, want := range map[string]bool{"nil": false, "nil\n": false, "t": true, `"x"`: true} {
+		if got := ParseBool(printed); got != want {
+			t.Errorf("ParseBool(%q) = %v, want %v", printed, got, want)
+		}
+	}
+}
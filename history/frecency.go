@@ -0,0 +1,79 @@
+package history
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/solodov/org-roam-alfred-items/alfred"
+)
+
+// FrecencyHalfLife is the age at which a selection counts half as much as a fresh one.
+const FrecencyHalfLife = 7 * day
+
+// decay returns the score as of time to, given the score as of time from, both in unix seconds.
+func decay(score float64, from, to int64) float64 {
+	if to <= from {
+		return score
+	}
+	return score * math.Exp2(-float64(time.Duration(to-from)*time.Second)/float64(FrecencyHalfLife))
+}
+
+// Frecency returns scores of previously selected items keyed by their Uid. Every selection recorded
+// for any of the triggers adds a weight that halves every FrecencyHalfLife, so both frequent and
+// recent selections score high. When byPrefix is set only selections made with a query that starts
+// with the given query, or is a prefix of it, are counted.
+func Frecency(triggers []string, query string, byPrefix bool) map[string]float64 {
+	scores := map[string]float64{}
+	db, err := Open()
+	if err != nil {
+		log.Printf("failed to open history database: %v\n", err)
+		return scores
+	}
+	cond, args := inTriggers(triggers)
+	rows, err := db.Query(`
+		SELECT i.item, q.query, q.score, q.last_used
+		FROM queries q JOIN items i ON i.id = q.item_id
+		WHERE i.`+cond, args...)
+	if err != nil {
+		log.Printf("history database query failed: %v\n", err)
+		return scores
+	}
+	defer rows.Close()
+	now := time.Now().Unix()
+	query = strings.ToLower(query)
+	uids := map[string]string{}
+	for rows.Next() {
+		var (
+			itemQuery, itemStr string
+			score              float64
+			lastUsed           int64
+		)
+		if err := rows.Scan(&itemStr, &itemQuery, &score, &lastUsed); err != nil {
+			log.Printf("history db row scan failed: %v\n", err)
+			continue
+		}
+		if byPrefix && strings.HasPrefix(itemQuery, hashedQueryPrefix) {
+			if itemQuery != hashQuery(query) {
+				continue
+			}
+		} else if byPrefix {
+			itemQuery = strings.ToLower(itemQuery)
+			if !strings.HasPrefix(itemQuery, query) && !strings.HasPrefix(query, itemQuery) {
+				continue
+			}
+		}
+		uid, found := uids[itemStr]
+		if !found {
+			var item alfred.Item
+			json.Unmarshal([]byte(itemStr), &item)
+			uid, uids[itemStr] = item.Uid, item.Uid
+		}
+		if uid != "" {
+			scores[uid] += decay(score, lastUsed, now)
+		}
+	}
+	return scores
+}
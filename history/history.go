@@ -1,13 +1,13 @@
 package history
 
 import (
+	"crypto/sha256"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"path"
-	"regexp"
 	"strings"
 	"time"
 
@@ -17,95 +17,278 @@ import (
 
 var Path string
 
-type Record struct {
-	Ts      int64
-	Trigger string
-	Item    string
+// db is shared by all callers so a long-running process doesn't reopen the database for every
+// request.
+var db struct {
+	path   string
+	handle *sql.DB
 }
 
-const schema = `
-  CREATE TABLE items (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    ts INTEGER NOT NULL,
-    trigger VARCHAR(32) NOT NULL,
-    query VARCHAR(128) NOT NULL,
-    item VARCHAR(1024) NOT NULL);
-  PRAGMA auto_vacuum = "incremental";
-  PRAGMA incremental_vacuum(10);`
-
+// Open returns the history database, initializing or migrating it if necessary. The handle is
+// shared, callers must not close it.
 func Open() (*sql.DB, error) {
-	initDb := false
+	if db.handle != nil && db.path == Path {
+		return db.handle, nil
+	}
+	existed := true
 	if _, err := os.Stat(Path); err != nil {
 		log.Print("history database doesn't exist, initializing...")
 		if err := os.MkdirAll(path.Dir(Path), 0700); err != nil {
 			return nil, fmt.Errorf("failed to create directory for history database: %v", err)
 		}
-		initDb = true
+		existed = false
 	}
-	db, err := sql.Open("sqlite3_extended", Path)
+	handle, err := sql.Open("sqlite3_extended", Path)
 	if err != nil {
 		return nil, err
 	}
-	if initDb {
-		if _, err = db.Exec(schema); err != nil {
-			return nil, err
-		}
+	if err := migrate(handle, Path, existed); err != nil {
+		handle.Close()
+		return nil, err
+	}
+	if err := setupAutoVacuum(handle); err != nil {
+		handle.Close()
+		return nil, err
+	}
+	if err := indexMissingTerms(handle); err != nil {
+		handle.Close()
+		return nil, err
+	}
+	if db.handle != nil {
+		db.handle.Close()
 	}
-	return db, nil
+	db.path, db.handle = Path, handle
+	return handle, nil
 }
 
 func init() {
-	regex := func(re, s string) (bool, error) {
-		return regexp.MatchString(re, s)
-	}
 	sql.Register("sqlite3_extended",
 		&sqlite3.SQLiteDriver{
 			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
-				return conn.RegisterFunc("regexp", regex, true)
+				if _, err := conn.Exec("PRAGMA foreign_keys = ON", nil); err != nil {
+					return err
+				}
+				return conn.RegisterFunc("decay", decay, true)
 			},
 		})
 }
 
-func FindMatchingItems(trigger, alfredQuery string) (items []alfred.Item) {
+// ItemKey identifies the item in history, selections of items with the same key are counted
+// together.
+func ItemKey(item alfred.Item) string {
+	key, _ := json.Marshal([]string{item.Arg, item.Uid, item.Title})
+	return fmt.Sprintf("%x", sha256.Sum256(key))
+}
+
+// StoredKey returns the key of an item suggested by FindMatchingItems, whose title is changed from
+// the stored one.
+func StoredKey(item alfred.Item) string {
+	var stored alfred.Item
+	if err := json.Unmarshal([]byte(item.Variables.HistItem), &stored); err != nil {
+		return ItemKey(item)
+	}
+	return ItemKey(stored)
+}
+
+// Add records a selection of the item, which is its JSON representation, made with the query, and
+// prunes items of the trigger according to Retention. Selections denied by Privacy are ignored.
+func Add(trigger, query, item string, ts int64) error {
+	var parsed alfred.Item
+	if err := json.Unmarshal([]byte(item), &parsed); err != nil {
+		return fmt.Errorf("invalid item json: %v", err)
+	}
+	if !Privacy.recorded(parsed, query) {
+		return nil
+	}
+	// FindMatchingItems marks items it suggests, the mark isn't part of the item. Hashed queries
+	// aren't stored in the item either.
+	fromHistory := parsed.Variables.FromHistory != ""
+	if fromHistory || (Privacy.HashQueries && parsed.Variables.Query != "") {
+		parsed.Variables.FromHistory = ""
+		if Privacy.HashQueries {
+			parsed.Variables.Query = ""
+		}
+		data, err := json.Marshal(parsed)
+		if err != nil {
+			return err
+		}
+		item = string(data)
+	}
+	if Privacy.HashQueries {
+		query = hashQuery(query)
+	}
+	db, err := Open()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	key := ItemKey(parsed)
+	id, err := add(tx, trigger, query, item, key, ts)
+	if err != nil {
+		return err
+	}
+	if err := addUse(tx, id, ts, fromHistory); err != nil {
+		return err
+	}
+	if err := record(tx, change{Ts: ts, Op: "add", Trigger: trigger, Key: key, Item: json.RawMessage(item), Query: query, FromHistory: fromHistory}); err != nil {
+		return err
+	}
+	retention := Retention
+	retention.Triggers = []string{trigger}
+	removed, err := prune(tx, retention, time.Unix(ts, 0))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if removed > 0 {
+		return vacuum(db)
+	}
+	return nil
+}
+
+// add updates aggregates of the item and the query. Scores are kept as of last_used and decayed
+// to the time of each new selection before adding it.
+func add(tx *sql.Tx, trigger, query, item, key string, ts int64) (id int64, err error) {
+	if err := tx.QueryRow(`
+		INSERT INTO items (trigger, key, item, count, first_used, last_used, score)
+		VALUES (?, ?, ?, 1, ?, ?, 1)
+		ON CONFLICT (trigger, key) DO UPDATE SET
+			item = excluded.item,
+			count = count + 1,
+			last_used = excluded.last_used,
+			score = decay(score, last_used, excluded.last_used) + 1
+		RETURNING id`,
+		trigger, key, item, ts, ts,
+	).Scan(&id); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO queries (item_id, query, count, last_used, score)
+		VALUES (?, ?, 1, ?, 1)
+		ON CONFLICT (item_id, query) DO UPDATE SET
+			count = count + 1,
+			last_used = excluded.last_used,
+			score = decay(score, last_used, excluded.last_used) + 1`,
+		id, query, ts); err != nil {
+		return 0, err
+	}
+	return id, indexTerms(tx, id)
+}
+
+// addUse logs a selection for statistics, fromHistory tells whether the item was suggested from
+// history.
+func addUse(tx *sql.Tx, id, ts int64, fromHistory bool) error {
+	_, err := tx.Exec(`INSERT INTO uses (item_id, ts, from_history) VALUES (?, ?, ?)`, id, ts, fromHistory)
+	return err
+}
+
+// itemUse is a logged selection of an item, carried by snapshots and entry changes so that
+// statistics survive sync.
+type itemUse struct {
+	Ts          int64 `json:"ts"`
+	FromHistory bool  `json:"from_history,omitempty"`
+}
+
+// itemUses returns logged selections of the item in time order.
+func itemUses(tx *sql.Tx, id int64) (uses []itemUse, err error) {
+	rows, err := tx.Query(`SELECT ts, from_history FROM uses WHERE item_id = ? ORDER BY ts, rowid`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u itemUse
+		if err := rows.Scan(&u.Ts, &u.FromHistory); err != nil {
+			return nil, err
+		}
+		uses = append(uses, u)
+	}
+	return uses, rows.Err()
+}
+
+// AnyTerm makes FindMatchingItems return items that match any term of the query instead of all
+// of them.
+var AnyTerm bool
+
+// maxMatchingItems limits the number of items returned by FindMatchingItems.
+const maxMatchingItems = 40
+
+// FindMatchingItems returns items previously selected with any of the triggers that match the
+// current query. Every query term must be a prefix of a word of the item title or of a query the
+// item was selected with, ignoring case and diacritics. Queries without terms match all items.
+// Items matching more terms come first, then the most recently used ones. Titles are prefixed with
+// the time since last use.
+func FindMatchingItems(triggers []string, alfredQuery string) (items []alfred.Item) {
 	db, err := Open()
 	if err != nil {
-		log.Println("failed to open history database: %v", err)
+		log.Printf("failed to open history database: %v\n", err)
 		return items
 	}
-	row, err := db.Query(
-		`SELECT ts, item FROM items
-     WHERE trigger = ? AND query REGEXP ?
-     ORDER BY ts DESC LIMIT 40`,
-		trigger,
-		strings.Join(strings.Split(alfredQuery, " "), "|"),
-	)
+	terms := tokenize(alfredQuery)
+	required := len(terms)
+	if AnyTerm && required > 1 {
+		required = 1
+	}
+	matched, args := termsCond(terms)
+	cond, triggerArgs := inTriggers(triggers)
+	args = append(append(args, triggerArgs...), required, maxMatchingItems)
+	rows, err := db.Query(`
+		SELECT last_used, item, `+matched+` AS matched
+		FROM items
+		WHERE `+cond+` AND matched >= ?
+		ORDER BY matched DESC, last_used DESC, id DESC
+		LIMIT ?`, args...)
 	if err != nil {
 		log.Printf("history database query failed: %v\n", err)
 		return items
 	}
-	seen := map[string]bool{}
-	for row.Next() {
-		var ts int64
-		var itemStr string
-		if err = row.Scan(&ts, &itemStr); err != nil {
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			lastUsed int64
+			itemStr  string
+			matched  int
+			item     alfred.Item
+		)
+		if err := rows.Scan(&lastUsed, &itemStr, &matched); err != nil {
 			log.Printf("history db row scan failed: %v\n", err)
 			continue
 		}
-		if _, found := seen[itemStr]; found {
-			continue
-		}
-		seen[itemStr] = true
-		var item alfred.Item
 		if err := json.Unmarshal([]byte(itemStr), &item); err != nil {
 			log.Printf("invalid item json: %v\n", err)
 			continue
 		}
-		item.Title = fmt.Sprintf("%s: %s", formatDuration(time.Now().Sub(time.Unix(ts, 0))), item.Title)
+		// Selecting a suggestion records the item as it was stored, with a mark for statistics.
+		marked := item
+		marked.Variables.FromHistory = "1"
+		if data, err := json.Marshal(marked); err == nil {
+			item.Variables.HistItem = string(data)
+		}
+		item.Title = fmt.Sprintf("%s: %s", formatDuration(time.Now().Sub(time.Unix(lastUsed, 0))), item.Title)
 		items = append(items, item)
 	}
 	return items
 }
 
+// inTriggers returns an SQL condition that matches rows of the triggers, or all rows when there are
+// none, and its arguments.
+func inTriggers(triggers []string) (string, []any) {
+	if len(triggers) == 0 {
+		return "1", nil
+	}
+	args := make([]any, len(triggers))
+	for i, t := range triggers {
+		args[i] = t
+	}
+	return "trigger IN (?" + strings.Repeat(", ?", len(triggers)-1) + ")", args
+}
+
 const (
 	hour  = 60 * time.Minute
 	day   = 24 * hour
@@ -134,8 +317,12 @@ func formatDuration(d time.Duration) string {
 
 func FinalizeItems(items *[]alfred.Item) {
 	for i := range *items {
-		if (*items)[i].Save {
-			if res, err := json.Marshal((*items)[i]); err == nil {
+		item := (*items)[i]
+		if item.Save && !(item.FromQuery && Privacy.HashQueries) && Privacy.recorded(item, item.Variables.Query) {
+			if Privacy.HashQueries {
+				item.Variables.Query = ""
+			}
+			if res, err := json.Marshal(item); err == nil {
 				(*items)[i].Variables.HistItem = string(res)
 			}
 		}
@@ -0,0 +1,331 @@
+package history
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/solodov/org-roam-alfred-items/alfred"
+)
+
+// Entry is an item with its aggregated use, it's the unit of listing, export and import.
+type Entry struct {
+	// Id is the row ID, it's local to the database and isn't exported.
+	Id        int64           `json:"-"`
+	Trigger   string          `json:"trigger"`
+	Item      json.RawMessage `json:"item"`
+	Count     int             `json:"count"`
+	FirstUsed int64           `json:"first_used"`
+	LastUsed  int64           `json:"last_used"`
+	Score     float64         `json:"score"`
+	Queries   []QueryUse      `json:"queries"`
+}
+
+// QueryUse counts selections of an item made with one query.
+type QueryUse struct {
+	Query    string  `json:"query"`
+	Count    int     `json:"count"`
+	LastUsed int64   `json:"last_used"`
+	Score    float64 `json:"score"`
+}
+
+// Decode returns the item, invalid items are returned empty.
+func (e *Entry) Decode() (item alfred.Item) {
+	json.Unmarshal(e.Item, &item)
+	return item
+}
+
+// FormatAge returns a rough time since ts, like the prefix of history item titles.
+func FormatAge(ts int64) string {
+	return formatDuration(time.Since(time.Unix(ts, 0)))
+}
+
+// List returns entries of the triggers, or of all triggers if there are none, most recently used
+// first.
+func List(triggers []string) ([]Entry, error) {
+	db, err := Open()
+	if err != nil {
+		return nil, err
+	}
+	return list(db, triggers)
+}
+
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func list(db querier, triggers []string) (entries []Entry, err error) {
+	cond, args := inTriggers(triggers)
+	rows, err := db.Query(`
+		SELECT id, trigger, item, count, first_used, last_used, score
+		FROM items
+		WHERE `+cond+`
+		ORDER BY last_used DESC, id DESC`,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byId := map[int64]int{}
+	for rows.Next() {
+		var (
+			e    Entry
+			item string
+		)
+		if err := rows.Scan(&e.Id, &e.Trigger, &item, &e.Count, &e.FirstUsed, &e.LastUsed, &e.Score); err != nil {
+			return nil, err
+		}
+		e.Item = json.RawMessage(item)
+		byId[e.Id] = len(entries)
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows, err = db.Query(`SELECT item_id, query, count, last_used, score FROM queries ORDER BY last_used DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id int64
+			q  QueryUse
+		)
+		if err := rows.Scan(&id, &q.Query, &q.Count, &q.LastUsed, &q.Score); err != nil {
+			return nil, err
+		}
+		if i, found := byId[id]; found {
+			entries[i].Queries = append(entries[i].Queries, q)
+		}
+	}
+	return entries, rows.Err()
+}
+
+// Remove deletes entries by row ID and returns the number of deleted entries.
+func Remove(ids []int64) (removed int64, err error) {
+	db, err := Open()
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	for _, id := range ids {
+		var trigger, key string
+		if err := tx.QueryRow(`DELETE FROM items WHERE id = ? RETURNING trigger, key`, id).Scan(&trigger, &key); err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		if err := record(tx, change{Ts: now, Op: "delete", Trigger: trigger, Key: key}); err != nil {
+			return 0, err
+		}
+		removed++
+	}
+	return removed, tx.Commit()
+}
+
+// RemoveMatching deletes entries of the triggers, or of all triggers if there are none, whose item
+// title, subtitle or arg match the expression.
+func RemoveMatching(re *regexp.Regexp, triggers []string) (int64, error) {
+	entries, err := List(triggers)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for i := range entries {
+		item := entries[i].Decode()
+		if re.MatchString(item.Title) || re.MatchString(item.Subtitle) || re.MatchString(item.Arg) {
+			ids = append(ids, entries[i].Id)
+		}
+	}
+	return Remove(ids)
+}
+
+// Redact removes entries of items that the rules deny, judging by their URL, queries they were
+// selected with and the query stored in the item. Hashed queries can't be matched, entries with
+// them are denied if query patterns match the uid, title, arg or autocomplete of the item, which is
+// where items recorded before queries were hashed have the query. It returns the number of removed
+// entries.
+func Redact(rules PrivacyRules) (int64, error) {
+	entries, err := List(nil)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for i := range entries {
+		e := &entries[i]
+		item := e.Decode()
+		denied := rules.Denies(item, item.Variables.Query)
+		for _, q := range e.Queries {
+			if strings.HasPrefix(q.Query, hashedQueryPrefix) {
+				for _, field := range []string{item.Uid, item.Title, item.Arg, item.Autocomplete} {
+					denied = denied || rules.DeniesQuery(field)
+				}
+			} else {
+				denied = denied || rules.DeniesQuery(q.Query)
+			}
+		}
+		if denied {
+			ids = append(ids, e.Id)
+		}
+	}
+	return Remove(ids)
+}
+
+// Export writes entries of the triggers, or of all triggers if there are none, as JSON lines.
+func Export(w io.Writer, triggers []string) error {
+	entries, err := List(triggers)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for i := range entries {
+		if err := enc.Encode(&entries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import merges exported entries and returns the number of entries read. Entries of items already
+// in history are merged so importing the same data again changes nothing: counts and scores take
+// the larger value, first and last use the wider range.
+func Import(r io.Reader) (imported int, err error) {
+	db, err := Open()
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return 0, fmt.Errorf("line %d: %v", line, err)
+		}
+		var item alfred.Item
+		if err := json.Unmarshal(e.Item, &item); err != nil {
+			return 0, fmt.Errorf("line %d: invalid entry", line)
+		}
+		key := ItemKey(item)
+		if _, err := merge(tx, &e, key, false); err != nil {
+			return 0, fmt.Errorf("line %d: %v", line, err)
+		}
+		if err := record(tx, change{Ts: now, Op: "import", Trigger: e.Trigger, Key: key, Entry: &e}); err != nil {
+			return 0, err
+		}
+		imported++
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return imported, tx.Commit()
+}
+
+// Retrigger moves entries of one trigger to another, entries of items that already exist under the
+// new trigger are combined with them. It returns the number of moved entries.
+func Retrigger(from, to string) (int, error) {
+	if from == to {
+		return 0, fmt.Errorf("can't move history of trigger %v to itself", from)
+	}
+	db, err := Open()
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	entries, err := list(tx, []string{from})
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().Unix()
+	for i := range entries {
+		e := &entries[i]
+		key := ItemKey(e.Decode())
+		if err := record(tx, change{Ts: now, Op: "delete", Trigger: from, Key: key}); err != nil {
+			return 0, err
+		}
+		uses, err := itemUses(tx, e.Id)
+		if err != nil {
+			return 0, err
+		}
+		e.Trigger = to
+		id, err := merge(tx, e, key, true)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(`UPDATE uses SET item_id = ? WHERE item_id = ?`, id, e.Id); err != nil {
+			return 0, err
+		}
+		if err := record(tx, change{Ts: now, Op: "entry", Trigger: to, Key: key, Entry: e, Uses: uses}); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM items WHERE trigger = ?`, from); err != nil {
+		return 0, err
+	}
+	return len(entries), tx.Commit()
+}
+
+// merge upserts the entry. Existing counts and scores are added to when sum is set, which combines
+// distinct selections, and take the larger value otherwise, which makes merging the same entry
+// again a no-op. Scores are combined as of the later last use. It returns the item ID.
+func merge(tx *sql.Tx, e *Entry, key string, sum bool) (int64, error) {
+	combine := "max(%s, %s)"
+	if sum {
+		combine = "%s + %s"
+	}
+	count := fmt.Sprintf(combine, "count", "excluded.count")
+	score := fmt.Sprintf(combine,
+		"decay(score, last_used, excluded.last_used)", "decay(excluded.score, excluded.last_used, last_used)")
+	var id int64
+	if err := tx.QueryRow(`
+		INSERT INTO items (trigger, key, item, count, first_used, last_used, score)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (trigger, key) DO UPDATE SET
+			item = iif(excluded.last_used > last_used, excluded.item, item),
+			count = `+count+`,
+			first_used = min(first_used, excluded.first_used),
+			score = `+score+`,
+			last_used = max(last_used, excluded.last_used)
+		RETURNING id`,
+		e.Trigger, key, string(e.Item), e.Count, e.FirstUsed, e.LastUsed, e.Score,
+	).Scan(&id); err != nil {
+		return 0, err
+	}
+	for _, q := range e.Queries {
+		if _, err := tx.Exec(`
+			INSERT INTO queries (item_id, query, count, last_used, score)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (item_id, query) DO UPDATE SET
+				count = `+count+`,
+				score = `+score+`,
+				last_used = max(last_used, excluded.last_used)`,
+			id, q.Query, q.Count, q.LastUsed, q.Score,
+		); err != nil {
+			return 0, err
+		}
+	}
+	return id, indexTerms(tx, id)
+}
@@ -0,0 +1,62 @@
+package history
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/solodov/org-roam-alfred-items/alfred"
+)
+
+func TestRetrigger(t *testing.T) {
+	setupHistory(t)
+	now := time.Now().Unix()
+	x := alfred.Item{Uid: "x", Title: "x", Arg: "https://x"}
+	addItem(t, "old", "go", x, now-300)
+	addItem(t, "old", "go", x, now-200)
+	addItem(t, "old", "", alfred.Item{Uid: "y", Title: "y", Arg: "https://y"}, now-200)
+	addItem(t, "new", "golang", x, now-100)
+	if moved, err := Retrigger("old", "new"); err != nil || moved != 2 {
+		t.Fatalf("Retrigger() = %d, %v, want 2 moved", moved, err)
+	}
+	db, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.Query(`
+		SELECT items.trigger, items.item ->> 'title', count(uses.item_id)
+		FROM items LEFT JOIN uses ON uses.item_id = items.id
+		GROUP BY items.id ORDER BY 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var (
+			trigger, title string
+			uses           int
+		)
+		if err := rows.Scan(&trigger, &title, &uses); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%v:%v=%d", trigger, title, uses))
+	}
+	// Uses of merged and moved items are kept.
+	if want := "new:x=3,new:y=1"; strings.Join(got, ",") != want {
+		t.Errorf("history has %q, want %q", got, want)
+	}
+}
+
+func TestRetriggerSame(t *testing.T) {
+	setupHistory(t)
+	addItem(t, "chrome", "", alfred.Item{Uid: "x", Title: "x", Arg: "https://x"}, time.Now().Unix())
+	if _, err := Retrigger("chrome", "chrome"); err == nil {
+		t.Error("Retrigger() to the same trigger succeeded")
+	}
+	entries, err := List(nil)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("List() = %v, %v, want the entry kept", entries, err)
+	}
+}
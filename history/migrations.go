@@ -0,0 +1,267 @@
+package history
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+)
+
+// migrations bring the database schema up to date, migration i upgrades version i to version i+1.
+// The version is stored in PRAGMA user_version. Released migrations must never change, schema
+// changes go into new migrations appended to the list.
+var migrations = []func(tx *sql.Tx) error{
+	// 1: the original schema. Databases created before versioning are at version 0 but already
+	// have the table.
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS items (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				ts INTEGER NOT NULL,
+				trigger VARCHAR(32) NOT NULL,
+				query VARCHAR(128) NOT NULL,
+				item VARCHAR(1024) NOT NULL)`)
+		return err
+	},
+	// 2: one row per trigger and item with aggregated use counts instead of one row per selection.
+	// Selections are kept for migration 4, which turns them into uses.
+	func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			ALTER TABLE items RENAME TO selections;
+			CREATE TABLE items (
+				id INTEGER PRIMARY KEY,
+				trigger TEXT NOT NULL,
+				key TEXT NOT NULL,
+				item TEXT NOT NULL,
+				count INTEGER NOT NULL,
+				first_used INTEGER NOT NULL,
+				last_used INTEGER NOT NULL,
+				score REAL NOT NULL,
+				UNIQUE (trigger, key));
+			CREATE TABLE queries (
+				item_id INTEGER NOT NULL REFERENCES items (id) ON DELETE CASCADE,
+				query TEXT NOT NULL,
+				count INTEGER NOT NULL,
+				last_used INTEGER NOT NULL,
+				score REAL NOT NULL,
+				PRIMARY KEY (item_id, query));`); err != nil {
+			return err
+		}
+		selections, err := readSelections(tx)
+		if err != nil {
+			return err
+		}
+		type aggregate struct {
+			item                string
+			count               int
+			firstUsed, lastUsed int64
+			score               float64
+			queries             map[string]*aggregate
+			order               int
+		}
+		// Scores are computed as of the last selection with the half-life of this version.
+		use := func(a *aggregate, ts int64) {
+			if a.count > 0 {
+				a.score *= math.Exp2(-float64(ts-a.lastUsed) / float64(7*24*60*60))
+			}
+			a.score++
+			a.count++
+			a.lastUsed = ts
+		}
+		type itemId struct{ trigger, key string }
+		items := map[itemId]*aggregate{}
+		for _, s := range selections {
+			key, ok := selectionKey(s.item)
+			if !ok {
+				// Old versions stored anything that was valid JSON.
+				continue
+			}
+			a, found := items[itemId{s.trigger, key}]
+			if !found {
+				a = &aggregate{firstUsed: s.ts, queries: map[string]*aggregate{}, order: len(items)}
+				items[itemId{s.trigger, key}] = a
+			}
+			a.item = s.item
+			use(a, s.ts)
+			q, found := a.queries[s.query]
+			if !found {
+				q = &aggregate{}
+				a.queries[s.query] = q
+			}
+			use(q, s.ts)
+		}
+		ids := make([]itemId, len(items))
+		for id, a := range items {
+			ids[a.order] = id
+		}
+		for _, id := range ids {
+			a := items[id]
+			var rowId int64
+			if err := tx.QueryRow(`
+				INSERT INTO items (trigger, key, item, count, first_used, last_used, score)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+				RETURNING id`,
+				id.trigger, id.key, a.item, a.count, a.firstUsed, a.lastUsed, a.score,
+			).Scan(&rowId); err != nil {
+				return err
+			}
+			for query, q := range a.queries {
+				if _, err := tx.Exec(`
+					INSERT INTO queries (item_id, query, count, last_used, score)
+					VALUES (?, ?, ?, ?, ?)`,
+					rowId, query, q.count, q.lastUsed, q.score); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	},
+	// 3: sync state and local changes not yet written to the sync directory.
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE sync (
+				name TEXT PRIMARY KEY,
+				value TEXT NOT NULL);
+			CREATE TABLE changes (
+				seq INTEGER PRIMARY KEY AUTOINCREMENT,
+				change TEXT NOT NULL);`)
+		return err
+	},
+	// 4: log of individual selections for statistics, starting with the selections kept by
+	// migration 2. Selections made between versions 2 and 4 are only counted in items.
+	func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			CREATE TABLE uses (
+				item_id INTEGER NOT NULL REFERENCES items (id) ON DELETE CASCADE,
+				ts INTEGER NOT NULL,
+				from_history INTEGER NOT NULL);
+			CREATE INDEX uses_item_id ON uses (item_id);
+			CREATE INDEX uses_ts ON uses (ts);`); err != nil {
+			return err
+		}
+		selections, err := readSelections(tx)
+		if err != nil {
+			return err
+		}
+		for _, s := range selections {
+			key, ok := selectionKey(s.item)
+			if !ok {
+				continue
+			}
+			// Items pruned since migration 2 have no row to refer to.
+			if _, err := tx.Exec(`
+				INSERT INTO uses (item_id, ts, from_history)
+				SELECT id, ?, 0 FROM items WHERE trigger = ? AND key = ?`,
+				s.ts, s.trigger, key); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(`DROP TABLE selections`)
+		return err
+	},
+	// 5: words of titles and queries of items, filled in by indexTerms.
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`ALTER TABLE items ADD COLUMN terms TEXT`)
+		return err
+	},
+}
+
+type selection struct {
+	ts                   int64
+	trigger, query, item string
+}
+
+// readSelections returns rows of the selections table of schema versions 0 to 3, oldest first.
+func readSelections(tx *sql.Tx) (selections []selection, err error) {
+	rows, err := tx.Query(`SELECT ts, trigger, query, item FROM selections ORDER BY ts, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s selection
+		if err := rows.Scan(&s.ts, &s.trigger, &s.query, &s.item); err != nil {
+			return nil, err
+		}
+		selections = append(selections, s)
+	}
+	return selections, rows.Err()
+}
+
+// selectionKey is ItemKey of the selected item as of schema version 2, false for items that are
+// not JSON objects. Migrations must not depend on ItemKey, which may change.
+func selectionKey(item string) (string, bool) {
+	var fields struct {
+		Uid   string `json:"uid"`
+		Title string `json:"title"`
+		Arg   string `json:"arg"`
+	}
+	if err := json.Unmarshal([]byte(item), &fields); err != nil {
+		return "", false
+	}
+	key, _ := json.Marshal([]string{fields.Arg, fields.Uid, fields.Title})
+	return fmt.Sprintf("%x", sha256.Sum256(key)), true
+}
+
+// SchemaVersion is the version of the schema this binary works with.
+var SchemaVersion = len(migrations)
+
+func schemaVersion(q interface {
+	QueryRow(query string, args ...any) *sql.Row
+}) (version int, err error) {
+	err = q.QueryRow(`PRAGMA user_version`).Scan(&version)
+	return version, err
+}
+
+// migrate applies pending migrations, each in its own transaction. Existing databases are copied
+// to a backup file next to the database first.
+func migrate(handle *sql.DB, path string, existed bool) error {
+	version, err := schemaVersion(handle)
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("history database version %d is newer than supported version %d", version, SchemaVersion)
+	}
+	if version == SchemaVersion {
+		return nil
+	}
+	if existed {
+		backup := fmt.Sprintf("%s.v%d.bak", path, version)
+		log.Printf("migrating history database from version %d to %d, backup in %v\n", version, SchemaVersion, backup)
+		// A backup left by an earlier failed attempt is of the same version, VACUUM INTO refuses to
+		// overwrite it.
+		os.Remove(backup)
+		if _, err := handle.Exec(`VACUUM INTO ?`, backup); err != nil {
+			return fmt.Errorf("history database backup failed: %v", err)
+		}
+	}
+	for ; version < SchemaVersion; version++ {
+		if err := applyMigration(handle, version); err != nil {
+			return fmt.Errorf("history database migration to version %d failed: %v", version+1, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(handle *sql.DB, version int) error {
+	tx, err := handle.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// Another process may have migrated the database in the meantime.
+	if current, err := schemaVersion(tx); err != nil || current != version {
+		return err
+	}
+	if err := migrations[version](tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,162 @@
+package history
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/solodov/org-roam-alfred-items/alfred"
+)
+
+// openFixture creates a database from testdata/v<version>.sql and opens it with Open, which
+// migrates it to the current version.
+func openFixture(t *testing.T, version int) *sql.DB {
+	t.Helper()
+	script, err := os.ReadFile(filepath.Join("testdata", fmt.Sprintf("v%d.sql", version)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Path = filepath.Join(t.TempDir(), "history.db")
+	handle, err := sql.Open("sqlite3_extended", Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handle.Exec(string(script)); err != nil {
+		t.Fatal(err)
+	}
+	handle.Close()
+	db, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestMigrate(t *testing.T) {
+	for fixture := 0; fixture < SchemaVersion; fixture++ {
+		db := openFixture(t, fixture)
+		name := fmt.Sprintf("v%d", fixture)
+		if version, err := schemaVersion(db); err != nil || version != SchemaVersion {
+			t.Errorf("%s: version = %d, %v, want %d", name, version, err, SchemaVersion)
+		}
+		for table, want := range map[string]int{"items": 3, "queries": 3, "uses": 4} {
+			var got int
+			if err := db.QueryRow(`SELECT count(*) FROM ` + table).Scan(&got); err != nil || got != want {
+				t.Errorf("%s: %s rows = %d, %v, want %d", name, table, got, err, want)
+			}
+		}
+		var tables int
+		if err := db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE name = 'selections'`).Scan(&tables); err != nil || tables != 0 {
+			t.Errorf("%s: selections table is left over, %v", name, err)
+		}
+		// The second selection of the chrome item came a half-life after the first one.
+		var (
+			count     int
+			firstUsed int64
+			score     float64
+			item      string
+		)
+		if err := db.QueryRow(`
+			SELECT count, first_used, score, item FROM items WHERE trigger = 'chrome' AND key = ?`,
+			selectionKeyOf(t, `{"uid":"a","title":"Go","arg":"https://go.dev"}`),
+		).Scan(&count, &firstUsed, &score, &item); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if count != 2 || firstUsed != 1700000000 || score != 1.5 || item != `{"uid":"a","title":"Go","arg":"https://go.dev","subtitle":"newer"}` {
+			t.Errorf("%s: chrome item = %d, %d, %v, %s", name, count, firstUsed, score, item)
+		}
+		if err := db.QueryRow(`
+			SELECT q.count, q.score FROM queries q JOIN items i ON i.id = q.item_id
+			WHERE i.trigger = 'chrome' AND q.query = 'go'`).Scan(&count, &score); err != nil || count != 2 || score != 1.5 {
+			t.Errorf("%s: chrome query = %d, %v, %v", name, count, score, err)
+		}
+		var missingTerms int
+		if err := db.QueryRow(`SELECT count(*) FROM items WHERE terms IS NULL`).Scan(&missingTerms); err != nil || missingTerms != 0 {
+			t.Errorf("%s: %d items without terms, %v", name, missingTerms, err)
+		}
+	}
+}
+
+// TestMigrateBackup makes sure the database is backed up as it was before migrating.
+func TestMigrateBackup(t *testing.T) {
+	openFixture(t, 3)
+	backup, err := sql.Open("sqlite3_extended", Path+".v3.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+	if version, err := schemaVersion(backup); err != nil || version != 3 {
+		t.Errorf("backup version = %d, %v, want 3", version, err)
+	}
+	for table, want := range map[string]int{"items": 3, "queries": 3, "selections": 5} {
+		var got int
+		if err := backup.QueryRow(`SELECT count(*) FROM ` + table).Scan(&got); err != nil || got != want {
+			t.Errorf("backup %s rows = %d, %v, want %d", table, got, err, want)
+		}
+	}
+	// The backup can be restored, which migrates it again.
+	backup.Close()
+	Path += ".v3.bak"
+	db, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uses int
+	if err := db.QueryRow(`SELECT count(*) FROM uses`).Scan(&uses); err != nil || uses != 4 {
+		t.Errorf("restored backup has %d uses, %v, want 4", uses, err)
+	}
+
+	// A fresh database has nothing to back up.
+	setupHistory(t)
+	if _, err := Open(); err != nil {
+		t.Fatal(err)
+	}
+	if backups, _ := filepath.Glob(Path + ".v*.bak"); len(backups) != 0 {
+		t.Errorf("new database has backups %v", backups)
+	}
+}
+
+func selectionKeyOf(t *testing.T, item string) string {
+	t.Helper()
+	key, ok := selectionKey(item)
+	if !ok {
+		t.Fatalf("selectionKey(%q) failed", item)
+	}
+	return key
+}
+
+// TestSelectionKey makes sure keys of migrated items are those Add uses for new selections.
+func TestSelectionKey(t *testing.T) {
+	item := `{"uid":"a","title":"Go","arg":"https://go.dev","subtitle":"newer"}`
+	var parsed alfred.Item
+	if err := json.Unmarshal([]byte(item), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := selectionKeyOf(t, item), ItemKey(parsed); got != want {
+		t.Errorf("selectionKey() = %v, want %v", got, want)
+	}
+	if _, ok := selectionKey(`"not an item"`); ok {
+		t.Error("selectionKey() accepted a string")
+	}
+}
+
+// TestMigratedStats makes sure selections logged before schema version 2 count in statistics.
+func TestMigratedStats(t *testing.T) {
+	openFixture(t, 0)
+	days := int(time.Since(time.Unix(1700000000, 0))/day) + 2
+	stats, err := ComputeStats(nil, 1, days)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, d := range stats.Days {
+		total += d.Count
+	}
+	if total != 4 || stats.Fresh != 4 || stats.FromHistory != 0 {
+		t.Errorf("ComputeStats() counted %d days, %d fresh and %d from history selections, want 4, 4, 0", total, stats.Fresh, stats.FromHistory)
+	}
+}
@@ -0,0 +1,81 @@
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/solodov/org-roam-alfred-items/alfred"
+)
+
+type PrivacyRules struct {
+	// Incognito disables recording altogether.
+	Incognito bool
+	// DenyHosts are hosts of item URLs that are never recorded, subdomains included.
+	DenyHosts []string
+	// DenyQueries match queries whose items are never recorded.
+	DenyQueries []*regexp.Regexp
+	// HashQueries records hashes of query words instead of queries. History items are then only
+	// suggested for whole words and prefix frecency only counts identical queries. Items made of
+	// the query, like search items, aren't recorded, titles, args and other fields of recorded
+	// items stay in plaintext.
+	HashQueries bool
+}
+
+// Privacy is applied by FinalizeItems and Add.
+var Privacy PrivacyRules
+
+// Denies reports whether the item selected with the query matches deny patterns.
+func (p *PrivacyRules) Denies(item alfred.Item, query string) bool {
+	return p.DeniesHost(item.Arg) || p.DeniesQuery(query)
+}
+
+// DeniesHost reports whether the arg is a URL of a denied host.
+func (p *PrivacyRules) DeniesHost(arg string) bool {
+	u, err := url.Parse(arg)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, denied := range p.DenyHosts {
+		denied = strings.ToLower(denied)
+		if host == denied || strings.HasSuffix(host, "."+denied) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PrivacyRules) DeniesQuery(query string) bool {
+	for _, re := range p.DenyQueries {
+		if re.MatchString(query) {
+			return true
+		}
+	}
+	return false
+}
+
+// recorded reports whether a selection of the item with the query may be recorded.
+func (p *PrivacyRules) recorded(item alfred.Item, query string) bool {
+	return !p.Incognito && !p.Denies(item, query)
+}
+
+// hashedQueryPrefix marks queries stored as hashes of their words.
+const hashedQueryPrefix = "#"
+
+// hashQuery returns hashes of the query words. They hide queries from casual reading, short words
+// are easy to recover with a dictionary.
+func hashQuery(query string) string {
+	var hashes []string
+	for _, token := range tokenize(query) {
+		hashes = append(hashes, hashToken(token))
+	}
+	return hashedQueryPrefix + strings.Join(hashes, " ")
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,84 @@
+package history
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/solodov/org-roam-alfred-items/alfred"
+)
+
+func TestFinalizeItemsHashQueries(t *testing.T) {
+	setupHistory(t)
+	Privacy.HashQueries = true
+	items := []alfred.Item{
+		{Uid: "node", Title: "Node", Arg: "id", Variables: alfred.Variables{Query: "secret"}, Save: true},
+		{Uid: "search:google:secret", Title: "search secret", Arg: "https://www.google.com/search?q=secret", Variables: alfred.Variables{Query: "secret"}, Save: true, FromQuery: true},
+	}
+	FinalizeItems(&items)
+	if strings.Contains(items[0].Variables.HistItem, "secret") || items[0].Variables.HistItem == "" {
+		t.Errorf("HistItem = %q, want the item without the query", items[0].Variables.HistItem)
+	}
+	if items[1].Variables.HistItem != "" {
+		t.Errorf("HistItem = %q, want items made of the query not recorded", items[1].Variables.HistItem)
+	}
+}
+
+func TestAddHashQueries(t *testing.T) {
+	h := newTestHost(t)
+	h.sync(t, t.TempDir())
+	Privacy.HashQueries = true
+	// Items of older versions still have the query in their variables.
+	addItem(t, "roam", "secret plans", alfred.Item{Uid: "node", Title: "Node", Arg: "id", Variables: alfred.Variables{Query: "secret plans"}}, time.Now().Unix())
+	db, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{
+		`SELECT item || ' ' || terms FROM items`,
+		`SELECT query FROM queries`,
+		`SELECT change FROM changes`,
+	} {
+		var stored string
+		if err := db.QueryRow(query).Scan(&stored); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(stored, "secret") {
+			t.Errorf("%s = %q, want no plaintext query", query, stored)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	setupHistory(t)
+	now := time.Now().Unix()
+	addItem(t, "chrome", "salary", alfred.Item{Uid: "plain", Title: "Plain", Arg: "https://a"}, now)
+	Privacy.HashQueries = true
+	addItem(t, "roam", "salary", alfred.Item{Uid: "hashed", Title: "Hashed", Arg: "id"}, now)
+	// Recorded before queries were hashed, but stored again with a hashed query.
+	addItem(t, "chrome", "salary", alfred.Item{Uid: "search:google:salary", Title: "search salary", Arg: "https://www.google.com/search?q=salary"}, now)
+	addItem(t, "chrome", "", alfred.Item{Uid: "host", Title: "Host", Arg: "https://denied.example.com/x"}, now)
+	addItem(t, "chrome", "other", alfred.Item{Uid: "kept", Title: "Kept", Arg: "https://b"}, now)
+	removed, err := Redact(PrivacyRules{
+		DenyHosts:   []string{"example.com"},
+		DenyQueries: []*regexp.Regexp{regexp.MustCompile("sal")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := List(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uids []string
+	for i := range entries {
+		uids = append(uids, entries[i].Decode().Uid)
+	}
+	// The hashed query of the node can't be matched, its fields don't have the query.
+	sort.Strings(uids)
+	if got := strings.Join(uids, ","); removed != 3 || got != "hashed,kept" {
+		t.Errorf("Redact() removed %d, left %q, want 3 removed and hashed,kept left", removed, got)
+	}
+}
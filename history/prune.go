@@ -0,0 +1,149 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type PruneOptions struct {
+	// OlderThan removes items that weren't used for this long, zero keeps all.
+	OlderThan time.Duration
+	// MaxPerTrigger keeps only this many most recently used items of each trigger, zero keeps all.
+	MaxPerTrigger int
+	// Triggers limits pruning to some triggers, empty prunes all triggers.
+	Triggers []string
+}
+
+// Retention is applied after every write, see Add.
+var Retention PruneOptions
+
+// vacuumThreshold is the number of free pages that makes pruning return them to the file system.
+const vacuumThreshold = 256
+
+// Prune removes items according to options and returns the number of removed items.
+func Prune(opts PruneOptions) (int64, error) {
+	db, err := Open()
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	removed, err := prune(tx, opts, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if removed > 0 {
+		if err := vacuum(db); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// prune deletes items, queries of deleted items are deleted by the foreign key. Deletions are
+// recorded for sync so replaying changes doesn't bring the items back.
+func prune(tx *sql.Tx, opts PruneOptions, now time.Time) (removed int64, err error) {
+	cond, args := inTriggers(opts.Triggers)
+	if opts.OlderThan > 0 {
+		n, err := deleteItems(tx, now, `last_used < ? AND `+cond, append([]any{now.Add(-opts.OlderThan).Unix()}, args...))
+		if err != nil {
+			return 0, err
+		}
+		removed += n
+		// Selections of items that are still used are only kept for statistics.
+		if _, err := tx.Exec(`DELETE FROM uses WHERE ts < ? AND item_id IN (SELECT id FROM items WHERE `+cond+`)`,
+			append([]any{now.Add(-opts.OlderThan).Unix()}, args...)...); err != nil {
+			return 0, err
+		}
+	}
+	if opts.MaxPerTrigger > 0 {
+		n, err := deleteItems(tx, now, `
+			id IN (
+				SELECT id FROM (
+					SELECT id, row_number() OVER (PARTITION BY trigger ORDER BY last_used DESC, id DESC) AS n
+					FROM items
+					WHERE `+cond+`)
+				WHERE n > ?)`,
+			append(args, opts.MaxPerTrigger))
+		if err != nil {
+			return 0, err
+		}
+		removed += n
+	}
+	return removed, nil
+}
+
+// deleteItems deletes items matching the condition and records the deletions.
+func deleteItems(tx *sql.Tx, now time.Time, cond string, args []any) (int64, error) {
+	rows, err := tx.Query(`DELETE FROM items WHERE `+cond+` RETURNING trigger, key`, args...)
+	if err != nil {
+		return 0, err
+	}
+	var deleted []change
+	for rows.Next() {
+		c := change{Ts: now.Unix(), Op: "delete"}
+		if err := rows.Scan(&c.Trigger, &c.Key); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		deleted = append(deleted, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, c := range deleted {
+		if err := record(tx, c); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(deleted)), nil
+}
+
+// vacuum returns free pages to the file system once there are enough of them.
+func vacuum(db *sql.DB) error {
+	var free int
+	if err := db.QueryRow(`PRAGMA freelist_count`).Scan(&free); err != nil {
+		return err
+	}
+	if free < vacuumThreshold {
+		return nil
+	}
+	_, err := db.Exec(`PRAGMA incremental_vacuum`)
+	return err
+}
+
+// setupAutoVacuum switches the database to incremental auto-vacuum. The mode of a database with
+// tables only changes with a full VACUUM, which is needed once.
+func setupAutoVacuum(db *sql.DB) error {
+	ctx := context.Background()
+	// The pragma and VACUUM must run on the same connection.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var mode int
+	if err := conn.QueryRowContext(ctx, `PRAGMA auto_vacuum`).Scan(&mode); err != nil {
+		return err
+	}
+	// 2 is incremental.
+	if mode == 2 {
+		return nil
+	}
+	if _, err := conn.ExecContext(ctx, `PRAGMA auto_vacuum = incremental`); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `VACUUM`); err != nil {
+		return fmt.Errorf("history database vacuum failed: %v", err)
+	}
+	return nil
+}
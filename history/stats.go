@@ -0,0 +1,130 @@
+package history
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/solodov/org-roam-alfred-items/alfred"
+)
+
+type Stats struct {
+	Triggers []TriggerStats `json:"triggers"`
+	// Days counts selections per day, oldest first, days without selections included.
+	Days []DayCount `json:"days"`
+	// Hours counts selections per hour of the day in local time.
+	Hours [24]int `json:"hours"`
+	// FromHistory and Fresh count selections of items suggested from history and of other items.
+	FromHistory int `json:"from_history"`
+	Fresh       int `json:"fresh"`
+	// Unused are known triggers without any recorded selections.
+	Unused []string `json:"unused"`
+}
+
+type TriggerStats struct {
+	Trigger string `json:"trigger"`
+	// Selections is the total use count of all items of the trigger.
+	Selections int        `json:"selections"`
+	Items      int        `json:"items"`
+	Top        []ItemStat `json:"top"`
+}
+
+type ItemStat struct {
+	Title    string `json:"title"`
+	Arg      string `json:"arg,omitempty"`
+	Count    int    `json:"count"`
+	LastUsed int64  `json:"last_used"`
+}
+
+type DayCount struct {
+	Day   string `json:"day"`
+	Count int    `json:"count"`
+}
+
+// ComputeStats summarizes history. Daily, hourly and history share counts cover the last days and
+// come from uses, which miss selections made with schema versions 2 and 3, use counts of items
+// cover everything. Known triggers that have no items are reported as unused.
+func ComputeStats(known []string, top, days int) (stats Stats, err error) {
+	db, err := Open()
+	if err != nil {
+		return stats, err
+	}
+	rows, err := db.Query(`SELECT trigger, item, count, last_used FROM items ORDER BY count DESC, last_used DESC`)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	byTrigger := map[string]*TriggerStats{}
+	for rows.Next() {
+		var (
+			trigger, itemStr string
+			s                ItemStat
+		)
+		if err := rows.Scan(&trigger, &itemStr, &s.Count, &s.LastUsed); err != nil {
+			return stats, err
+		}
+		t, found := byTrigger[trigger]
+		if !found {
+			t = &TriggerStats{Trigger: trigger}
+			byTrigger[trigger] = t
+		}
+		t.Selections += s.Count
+		t.Items++
+		if len(t.Top) < top {
+			var item alfred.Item
+			json.Unmarshal([]byte(itemStr), &item)
+			s.Title, s.Arg = item.Title, item.Arg
+			t.Top = append(t.Top, s)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+	for _, t := range byTrigger {
+		stats.Triggers = append(stats.Triggers, *t)
+	}
+	sort.Slice(stats.Triggers, func(i, j int) bool {
+		if stats.Triggers[i].Selections != stats.Triggers[j].Selections {
+			return stats.Triggers[i].Selections > stats.Triggers[j].Selections
+		}
+		return stats.Triggers[i].Trigger < stats.Triggers[j].Trigger
+	})
+	for _, trigger := range known {
+		if _, found := byTrigger[trigger]; !found {
+			stats.Unused = append(stats.Unused, trigger)
+		}
+	}
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day()-days+1, 0, 0, 0, 0, time.Local)
+	dayIndex := map[string]int{}
+	for d := start; !d.After(now); d = d.AddDate(0, 0, 1) {
+		day := d.Format("2006-01-02")
+		dayIndex[day] = len(stats.Days)
+		stats.Days = append(stats.Days, DayCount{Day: day})
+	}
+	uses, err := db.Query(`SELECT ts, from_history FROM uses WHERE ts >= ?`, start.Unix())
+	if err != nil {
+		return stats, err
+	}
+	defer uses.Close()
+	for uses.Next() {
+		var (
+			ts          int64
+			fromHistory bool
+		)
+		if err := uses.Scan(&ts, &fromHistory); err != nil {
+			return stats, err
+		}
+		t := time.Unix(ts, 0)
+		if i, found := dayIndex[t.Format("2006-01-02")]; found {
+			stats.Days[i].Count++
+		}
+		stats.Hours[t.Hour()]++
+		if fromHistory {
+			stats.FromHistory++
+		} else {
+			stats.Fresh++
+		}
+	}
+	return stats, uses.Err()
+}
@@ -0,0 +1,561 @@
+package history
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// change is a line of a change log. Every host appends its own changes to its own log in the sync
+// directory, the history is the result of replaying changes of all hosts ordered by time, host and
+// sequence number on top of the newest snapshot. Replaying from scratch makes merging deterministic
+// and idempotent, snapshots and compaction keep replay cost bounded.
+type change struct {
+	Host string `json:"host"`
+	Seq  int64  `json:"seq"`
+	Ts   int64  `json:"ts"`
+	// Op is "add" for a selection, "delete" for a removed item, which is a tombstone for its
+	// earlier selections, "entry" for an aggregated entry added to existing counts, like local
+	// history at the first sync, and "import" for an entry merged like history import does.
+	// "compacted" is the first line of a compacted log, changes of the host up to its Seq were
+	// dropped from the log and are only in snapshots.
+	Op      string          `json:"op"`
+	Trigger string          `json:"trigger"`
+	Key     string          `json:"key"`
+	Item    json.RawMessage `json:"item,omitempty"`
+	Query   string          `json:"query,omitempty"`
+	Entry   *Entry          `json:"entry,omitempty"`
+	// Uses are logged selections of an "entry" change's item.
+	Uses []itemUse `json:"uses,omitempty"`
+	// FromHistory tells whether an added item was suggested from history.
+	FromHistory bool `json:"from_history,omitempty"`
+}
+
+type SyncStats struct {
+	// Hosts is the number of change logs in the sync directory.
+	Hosts int
+	// Exported is the number of local changes written to the sync directory.
+	Exported int
+	// Changes is the number of changes replayed on top of the snapshot.
+	Changes int
+	// Compacted is the number of changes dropped from this host's log.
+	Compacted int
+}
+
+// snapshot is the history replayed from changes of hosts up to their sequence numbers in Seqs.
+// Every host writes its snapshot to <host>.snapshot.json in the sync directory.
+type snapshot struct {
+	Host  string           `json:"host"`
+	Ts    int64            `json:"ts"`
+	Seqs  map[string]int64 `json:"seqs"`
+	Items []snapshotItem   `json:"items"`
+}
+
+type snapshotItem struct {
+	Entry
+	Key  string    `json:"key"`
+	Uses []itemUse `json:"uses,omitempty"`
+}
+
+// covers reports whether the change is part of the snapshot.
+func (s *snapshot) covers(c *change) bool {
+	return s != nil && c.Seq <= s.Seqs[c.Host]
+}
+
+var (
+	// snapshotChanges is the number of changes replayed on top of a snapshot that makes sync write
+	// a new snapshot.
+	snapshotChanges = 1000
+	// compactAfter is the age of changes that are dropped from logs once a snapshot covers them.
+	// Hosts that sync less often than that don't have the snapshot yet and fail to sync until they
+	// get it.
+	compactAfter = 7 * day
+)
+
+// record queues the change for the next sync. Changes are only recorded once sync is set up, until
+// then the first sync takes a snapshot of the whole history.
+func record(tx *sql.Tx, c change) error {
+	var host string
+	if err := tx.QueryRow(`SELECT value FROM sync WHERE name = 'host'`).Scan(&host); err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO changes (change) VALUES (?)`, string(data))
+	return err
+}
+
+// Sync writes local changes to this host's log in dir and replaces local history with the replay of
+// logs of all hosts on top of the newest snapshot. Once enough changes are replayed it writes a new
+// snapshot, old changes snapshots cover are dropped from this host's log. Retention is applied
+// after the replay.
+func Sync(dir string) (stats SyncStats, err error) {
+	db, err := Open()
+	if err != nil {
+		return stats, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return stats, err
+	}
+	defer tx.Rollback()
+	// Take the write lock right away so a concurrent sync waits instead of failing to upgrade its
+	// read lock later.
+	if _, err := tx.Exec(`UPDATE sync SET value = value WHERE name = 'host'`); err != nil {
+		return stats, err
+	}
+	host, err := syncHost(tx)
+	if err != nil {
+		return stats, err
+	}
+	logPath := filepath.Join(dir, host+".jsonl")
+	if host == "" {
+		if host, err = startSync(tx); err != nil {
+			return stats, err
+		}
+		logPath = filepath.Join(dir, host+".jsonl")
+	} else if _, err := os.Stat(logPath); err != nil {
+		// Replaying without this host's own changes would drop them from history.
+		return stats, fmt.Errorf("change log %v is missing, is %v the sync directory?", logPath, dir)
+	}
+	if stats.Exported, err = exportChanges(tx, host, logPath); err != nil {
+		return stats, err
+	}
+	changes, compacted, hosts, err := readChanges(dir)
+	if err != nil {
+		return stats, err
+	}
+	base, err := readSnapshot(dir, compacted)
+	if err != nil {
+		return stats, err
+	}
+	var pending []change
+	for i := range changes {
+		if !base.covers(&changes[i]) {
+			pending = append(pending, changes[i])
+		}
+	}
+	stats.Hosts, stats.Changes = hosts, len(pending)
+	if err := restore(tx, base); err != nil {
+		return stats, err
+	}
+	if err := replay(tx, pending); err != nil {
+		return stats, err
+	}
+	if len(pending) >= snapshotChanges {
+		if base, err = writeSnapshot(tx, dir, host, base, pending); err != nil {
+			return stats, err
+		}
+	}
+	if stats.Compacted, err = compactLog(logPath, host, base, compacted[host]); err != nil {
+		return stats, err
+	}
+	if _, err := prune(tx, Retention, time.Now()); err != nil {
+		return stats, err
+	}
+	if err := tx.Commit(); err != nil {
+		return stats, err
+	}
+	return stats, vacuum(db)
+}
+
+func syncHost(tx *sql.Tx) (host string, err error) {
+	err = tx.QueryRow(`SELECT value FROM sync WHERE name = 'host'`).Scan(&host)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return host, err
+}
+
+var hostUnsafeRe *regexp.Regexp
+
+func init() {
+	hostUnsafeRe = regexp.MustCompile(`[^a-z0-9-]+`)
+}
+
+// startSync assigns this host an ID and queues a snapshot of existing history.
+func startSync(tx *sql.Tx) (string, error) {
+	name, _ := os.Hostname()
+	name = strings.Trim(hostUnsafeRe.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if name == "" {
+		name = "host"
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	host := name + "-" + hex.EncodeToString(suffix)
+	if _, err := tx.Exec(`INSERT INTO sync (name, value) VALUES ('host', ?)`, host); err != nil {
+		return "", err
+	}
+	entries, err := list(tx, nil)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().Unix()
+	for i := range entries {
+		e := &entries[i]
+		uses, err := itemUses(tx, e.Id)
+		if err != nil {
+			return "", err
+		}
+		if err := record(tx, change{Ts: now, Op: "entry", Trigger: e.Trigger, Key: ItemKey(e.Decode()), Entry: e, Uses: uses}); err != nil {
+			return "", err
+		}
+	}
+	return host, nil
+}
+
+// exportChanges appends queued changes to the log and removes them from the queue. If the
+// transaction fails after the append the changes are appended again next time, which is harmless
+// because replay skips duplicates.
+func exportChanges(tx *sql.Tx, host, logPath string) (int, error) {
+	rows, err := tx.Query(`SELECT seq, change FROM changes ORDER BY seq`)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		buf     bytes.Buffer
+		last    int64
+		written int
+	)
+	for rows.Next() {
+		var data string
+		var c change
+		if err := rows.Scan(&last, &data); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if err := json.Unmarshal([]byte(data), &c); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		c.Host, c.Seq = host, last
+		line, err := json.Marshal(c)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		buf.Write(append(line, '\n'))
+		written++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`DELETE FROM changes WHERE seq <= ?`, last)
+	return written, err
+}
+
+// readChanges reads logs of all hosts in replay order without duplicates and returns sequence
+// numbers hosts compacted their logs up to. A last line without a newline is being written by its
+// host and is skipped.
+func readChanges(dir string) (changes []change, compacted map[string]int64, hosts int, err error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	compacted = map[string]int64{}
+	type id struct {
+		host string
+		seq  int64
+	}
+	seen := map[id]bool{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, nil, 0, err
+		}
+		hosts++
+		if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+			data = data[:i]
+		} else {
+			data = nil
+		}
+		for n, line := range bytes.Split(data, []byte("\n")) {
+			if len(line) == 0 {
+				continue
+			}
+			var c change
+			if err := json.Unmarshal(line, &c); err != nil || c.Host == "" {
+				log.Printf("%v:%d: invalid change, skipping\n", path, n+1)
+				continue
+			}
+			if c.Op == "compacted" {
+				compacted[c.Host] = c.Seq
+				continue
+			}
+			if seen[id{c.Host, c.Seq}] {
+				continue
+			}
+			seen[id{c.Host, c.Seq}] = true
+			changes = append(changes, c)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := &changes[i], &changes[j]
+		if a.Ts != b.Ts {
+			return a.Ts < b.Ts
+		}
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		return a.Seq < b.Seq
+	})
+	return changes, compacted, hosts, nil
+}
+
+// replay applies changes to history.
+func replay(tx *sql.Tx, changes []change) error {
+	for i := range changes {
+		c := &changes[i]
+		var err error
+		switch c.Op {
+		case "add":
+			var id int64
+			if id, err = add(tx, c.Trigger, c.Query, string(c.Item), c.Key, c.Ts); err == nil {
+				err = addUse(tx, id, c.Ts, c.FromHistory)
+			}
+		case "delete":
+			_, err = tx.Exec(`DELETE FROM items WHERE trigger = ? AND key = ?`, c.Trigger, c.Key)
+		case "entry", "import":
+			if c.Entry == nil {
+				continue
+			}
+			c.Entry.Trigger = c.Trigger
+			var id int64
+			if id, err = merge(tx, c.Entry, c.Key, c.Op == "entry"); err == nil {
+				err = addUses(tx, id, c.Uses)
+			}
+		default:
+			log.Printf("unknown change %q of host %v, skipping\n", c.Op, c.Host)
+		}
+		if err != nil {
+			return fmt.Errorf("replaying change %d of host %v: %v", c.Seq, c.Host, err)
+		}
+	}
+	return nil
+}
+
+// readSnapshot returns the newest snapshot that covers changes dropped from compacted logs, nil if
+// there are no snapshots and no compacted logs.
+func readSnapshot(dir string, compacted map[string]int64) (*snapshot, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.snapshot.json"))
+	if err != nil {
+		return nil, err
+	}
+	var newest *snapshot
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		s := &snapshot{}
+		if err := json.Unmarshal(data, s); err != nil {
+			log.Printf("%v: invalid snapshot, skipping: %v\n", path, err)
+			continue
+		}
+		complete := true
+		for host, seq := range compacted {
+			complete = complete && s.Seqs[host] >= seq
+		}
+		if complete && (newest == nil || s.Ts > newest.Ts || s.Ts == newest.Ts && s.Host > newest.Host) {
+			newest = s
+		}
+	}
+	if newest == nil && len(compacted) > 0 {
+		return nil, fmt.Errorf("change logs in %v are compacted but no snapshot covers them yet, sync again once the directory is synced", dir)
+	}
+	return newest, nil
+}
+
+// restore replaces history with the snapshot, or clears it if there is none.
+func restore(tx *sql.Tx, s *snapshot) error {
+	if _, err := tx.Exec(`DELETE FROM items`); err != nil || s == nil {
+		return err
+	}
+	for i := range s.Items {
+		item := &s.Items[i]
+		id, err := merge(tx, &item.Entry, item.Key, true)
+		if err != nil {
+			return err
+		}
+		if err := addUses(tx, id, item.Uses); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addUses(tx *sql.Tx, id int64, uses []itemUse) error {
+	for _, u := range uses {
+		if err := addUse(tx, id, u.Ts, u.FromHistory); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSnapshot saves history replayed from the base snapshot and changes as this host's snapshot
+// and returns it.
+func writeSnapshot(tx *sql.Tx, dir, host string, base *snapshot, changes []change) (*snapshot, error) {
+	s := &snapshot{Host: host, Ts: time.Now().Unix(), Seqs: map[string]int64{}}
+	if base != nil {
+		for h, seq := range base.Seqs {
+			s.Seqs[h] = seq
+		}
+	}
+	for i := range changes {
+		if changes[i].Seq > s.Seqs[changes[i].Host] {
+			s.Seqs[changes[i].Host] = changes[i].Seq
+		}
+	}
+	entries, err := list(tx, nil)
+	if err != nil {
+		return nil, err
+	}
+	byId := map[int64]int{}
+	for i := range entries {
+		byId[entries[i].Id] = i
+		s.Items = append(s.Items, snapshotItem{Entry: entries[i]})
+	}
+	rows, err := tx.Query(`SELECT id, key FROM items`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			id  int64
+			key string
+		)
+		if err := rows.Scan(&id, &key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		s.Items[byId[id]].Key = key
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows, err = tx.Query(`SELECT item_id, ts, from_history FROM uses ORDER BY ts, rowid`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			id int64
+			u  itemUse
+		)
+		if err := rows.Scan(&id, &u.Ts, &u.FromHistory); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		item := &s.Items[byId[id]]
+		item.Uses = append(item.Uses, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return s, writeFileAtomically(filepath.Join(dir, host+".snapshot.json"), data)
+}
+
+// compactLog drops changes the snapshot covers that are older than compactAfter from the log of the
+// host and returns the number of dropped changes. The log then starts with a "compacted" change.
+func compactLog(logPath, host string, base *snapshot, compactedSeq int64) (int, error) {
+	if base == nil {
+		return 0, nil
+	}
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-compactAfter).Unix()
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	// The marker line of an earlier compaction is replaced.
+	start := 0
+	var first change
+	if len(lines) > 0 && json.Unmarshal(lines[0], &first) == nil && first.Op == "compacted" {
+		start = 1
+	}
+	dropped := start
+	for ; dropped < len(lines); dropped++ {
+		var c change
+		if err := json.Unmarshal(lines[dropped], &c); err != nil || !bytes.HasSuffix(lines[dropped], []byte("\n")) {
+			break
+		}
+		if !base.covers(&c) || c.Ts >= cutoff {
+			break
+		}
+		compactedSeq = c.Seq
+	}
+	if dropped == start {
+		return 0, nil
+	}
+	marker, err := json.Marshal(change{Host: host, Seq: compactedSeq, Ts: time.Now().Unix(), Op: "compacted"})
+	if err != nil {
+		return 0, err
+	}
+	compacted := append(append(marker, '\n'), bytes.Join(lines[dropped:], nil)...)
+	return dropped - start, writeFileAtomically(logPath, compacted)
+}
+
+// writeFileAtomically replaces the file so that readers see either the old or the new contents.
+func writeFileAtomically(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,319 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/solodov/org-roam-alfred-items/alfred"
+)
+
+// testHost is a machine with its own history database, use makes it the current one.
+type testHost struct {
+	path string
+}
+
+func newTestHost(t *testing.T) *testHost {
+	t.Helper()
+	setupHistory(t)
+	return &testHost{filepath.Join(t.TempDir(), "history.db")}
+}
+
+func (h *testHost) use() {
+	Path = h.path
+}
+
+func (h *testHost) sync(t *testing.T, dir string) SyncStats {
+	t.Helper()
+	h.use()
+	stats, err := Sync(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+// state describes the history of the host in a form that doesn't depend on row IDs and row order.
+func (h *testHost) state(t *testing.T) string {
+	t.Helper()
+	h.use()
+	db, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := list(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, e := range entries {
+		sort.Slice(e.Queries, func(i, j int) bool { return e.Queries[i].Query < e.Queries[j].Query })
+		var uses int
+		if err := db.QueryRow(`SELECT count(*) FROM uses WHERE item_id = ?`, e.Id).Scan(&uses); err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, fmt.Sprintf("%s uses=%d", data, uses))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func (h *testHost) titles(t *testing.T) (titles []string) {
+	t.Helper()
+	h.use()
+	entries, err := List(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range entries {
+		titles = append(titles, entries[i].Trigger+":"+entries[i].Decode().Title)
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+func (h *testHost) add(t *testing.T, query, title string, ts int64) {
+	t.Helper()
+	h.use()
+	addItem(t, "chrome", query, alfred.Item{Uid: title, Title: title, Arg: "https://" + title}, ts)
+}
+
+func assertTitles(t *testing.T, h *testHost, want ...string) {
+	t.Helper()
+	if got := h.titles(t); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("history has %q, want %q", got, want)
+	}
+}
+
+func TestSyncDeterministic(t *testing.T) {
+	a, b := newTestHost(t), newTestHost(t)
+	dir := t.TempDir()
+	now := time.Now().Unix()
+	// History recorded before sync is set up is shared as aggregated entries.
+	a.add(t, "go", "x", now-300)
+	a.sync(t, dir)
+	b.add(t, "golang", "x", now-250)
+	b.add(t, "", "z", now-200)
+	b.sync(t, dir)
+	// Both hosts add selections before seeing each other's latest changes.
+	a.add(t, "", "y", now-100)
+	b.add(t, "go", "x", now-100)
+	a.sync(t, dir)
+	b.sync(t, dir)
+	a.sync(t, dir)
+	if sa, sb := a.state(t), b.state(t); sa != sb {
+		t.Errorf("hosts differ after sync:\n%s\n---\n%s", sa, sb)
+	}
+	assertTitles(t, a, "chrome:x", "chrome:y", "chrome:z")
+	before := a.state(t)
+	if stats := a.sync(t, dir); stats.Exported != 0 || stats.Hosts != 2 {
+		t.Errorf("Sync() = %+v, want nothing exported from 2 hosts", stats)
+	}
+	if after := a.state(t); after != before {
+		t.Errorf("repeated sync changed history:\n%s\n---\n%s", before, after)
+	}
+	entries, _ := List(nil)
+	for _, e := range entries {
+		if e.Decode().Title == "x" && (e.Count != 3 || len(e.Queries) != 2) {
+			t.Errorf("x has %d selections with %d queries, want 3 with 2", e.Count, len(e.Queries))
+		}
+	}
+}
+
+func TestSyncTombstones(t *testing.T) {
+	a, b := newTestHost(t), newTestHost(t)
+	dir := t.TempDir()
+	now := time.Now().Unix()
+	a.sync(t, dir)
+	a.add(t, "", "x", now-300)
+	a.add(t, "", "old", now-200)
+	a.add(t, "", "new", now-100)
+	a.sync(t, dir)
+	b.sync(t, dir)
+	assertTitles(t, b, "chrome:new", "chrome:old", "chrome:x")
+
+	b.use()
+	removed, err := RemoveMatching(regexp.MustCompile("^x$"), nil)
+	if err != nil || removed != 1 {
+		t.Fatalf("RemoveMatching() = %d, %v", removed, err)
+	}
+	b.sync(t, dir)
+	a.sync(t, dir)
+	assertTitles(t, a, "chrome:new", "chrome:old")
+
+	// Pruning is synced too and replaying doesn't bring pruned items back.
+	a.use()
+	if removed, err := Prune(PruneOptions{MaxPerTrigger: 1}); err != nil || removed != 1 {
+		t.Fatalf("Prune() = %d, %v", removed, err)
+	}
+	a.sync(t, dir)
+	assertTitles(t, a, "chrome:new")
+	b.sync(t, dir)
+	assertTitles(t, b, "chrome:new")
+
+	// Tombstones only cover earlier selections.
+	b.add(t, "", "x", time.Now().Unix()+1)
+	b.sync(t, dir)
+	a.sync(t, dir)
+	assertTitles(t, a, "chrome:new", "chrome:x")
+	if sa, sb := a.state(t), b.state(t); sa != sb {
+		t.Errorf("hosts differ after sync:\n%s\n---\n%s", sa, sb)
+	}
+}
+
+func TestSyncUses(t *testing.T) {
+	a, b := newTestHost(t), newTestHost(t)
+	dir := t.TempDir()
+	now := time.Now().Unix()
+	// Selections logged before sync is set up survive the first sync.
+	a.add(t, "go", "x", now-300)
+	a.add(t, "go", "x", now-200)
+	before := a.state(t)
+	if !strings.Contains(before, "uses=2") {
+		t.Fatalf("history before sync lacks uses:\n%s", before)
+	}
+	a.sync(t, dir)
+	if after := a.state(t); after != before {
+		t.Errorf("first sync changed history:\n%s\n---\n%s", before, after)
+	}
+	b.sync(t, dir)
+	if sa, sb := a.state(t), b.state(t); sa != sb {
+		t.Errorf("hosts differ after sync:\n%s\n---\n%s", sa, sb)
+	}
+
+	// Moved entries keep their uses on other hosts too.
+	a.use()
+	if moved, err := Retrigger("chrome", "web"); err != nil || moved != 1 {
+		t.Fatalf("Retrigger() = %d, %v", moved, err)
+	}
+	a.sync(t, dir)
+	b.sync(t, dir)
+	assertTitles(t, b, "web:x")
+	if sb := b.state(t); !strings.Contains(sb, "uses=2") {
+		t.Errorf("retriggered entry lost uses:\n%s", sb)
+	}
+}
+
+func TestSyncConcurrent(t *testing.T) {
+	a, b := newTestHost(t), newTestHost(t)
+	dir := t.TempDir()
+	a.sync(t, dir)
+	b.sync(t, dir)
+	// Open switches databases without locking, it's done before the goroutines share the handle.
+	a.use()
+	if _, err := Open(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			data, _ := json.Marshal(alfred.Item{Uid: "x", Title: "x", Arg: "https://x"})
+			errs <- Add("chrome", fmt.Sprint("q", i), string(data), now-int64(i))
+		}(i)
+		go func() {
+			defer wg.Done()
+			_, err := Sync(dir)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.sync(t, dir)
+	b.sync(t, dir)
+	if sa, sb := a.state(t), b.state(t); sa != sb {
+		t.Errorf("hosts differ after sync:\n%s\n---\n%s", sa, sb)
+	}
+	entries, _ := List(nil)
+	if len(entries) != 1 || entries[0].Count != 10 || len(entries[0].Queries) != 10 {
+		t.Errorf("history = %+v, want x selected 10 times", entries)
+	}
+}
+
+func TestSyncCompaction(t *testing.T) {
+	savedChanges, savedAfter := snapshotChanges, compactAfter
+	defer func() { snapshotChanges, compactAfter = savedChanges, savedAfter }()
+	snapshotChanges, compactAfter = 3, -time.Hour
+	a, b, c := newTestHost(t), newTestHost(t), newTestHost(t)
+	dir := t.TempDir()
+	now := time.Now().Unix()
+	a.sync(t, dir)
+	b.sync(t, dir)
+	for i := 0; i < 3; i++ {
+		a.add(t, "", fmt.Sprint("a", i), now-int64(100-i))
+	}
+	b.add(t, "", "b0", now-50)
+	if stats := a.sync(t, dir); stats.Compacted != 3 || stats.Changes != 3 {
+		t.Errorf("Sync() = %+v, want 3 changes replayed and compacted", stats)
+	}
+	// b0 is replayed on top of a's snapshot, which doesn't cover it.
+	if stats := b.sync(t, dir); stats.Compacted != 0 || stats.Changes != 1 {
+		t.Errorf("Sync() = %+v, want b0 replayed and not compacted", stats)
+	}
+	snapshotChanges = 1
+	if stats := b.sync(t, dir); stats.Compacted != 1 || stats.Changes != 1 {
+		t.Errorf("Sync() = %+v, want b0 covered by a new snapshot and compacted", stats)
+	}
+	if stats := a.sync(t, dir); stats.Compacted != 0 || stats.Changes != 0 {
+		t.Errorf("Sync() = %+v, want nothing to replay on top of the snapshot", stats)
+	}
+	want := a.state(t)
+	for _, h := range []*testHost{b, c} {
+		h.sync(t, dir)
+		if got := h.state(t); got != want {
+			t.Errorf("history differs from the compacting host:\n%s\n---\n%s", got, want)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, mustHost(t, a)+".jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"op":"compacted"`) {
+		t.Errorf("compacted log = %q, want a single marker line", data)
+	}
+	// Without snapshots compacted changes are gone and sync refuses to replay the rest.
+	snapshots, _ := filepath.Glob(filepath.Join(dir, "*.snapshot.json"))
+	for _, path := range snapshots {
+		os.Remove(path)
+	}
+	a.use()
+	if _, err := Sync(dir); err == nil || !strings.Contains(err.Error(), "no snapshot covers") {
+		t.Errorf("Sync() error = %v, want missing snapshot", err)
+	}
+	if got := a.state(t); got != want {
+		t.Errorf("failed sync changed history:\n%s\n---\n%s", got, want)
+	}
+}
+
+func mustHost(t *testing.T, h *testHost) string {
+	t.Helper()
+	h.use()
+	db, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var host string
+	if err := db.QueryRow(`SELECT value FROM sync WHERE name = 'host'`).Scan(&host); err != nil {
+		t.Fatal(err)
+	}
+	return host
+}
@@ -0,0 +1,12 @@
+CREATE TABLE items (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	ts INTEGER NOT NULL,
+	trigger VARCHAR(32) NOT NULL,
+	query VARCHAR(128) NOT NULL,
+	item VARCHAR(1024) NOT NULL);
+INSERT INTO items (ts, trigger, query, item) VALUES
+	(1700000000, 'chrome', 'go', '{"uid":"a","title":"Go","arg":"https://go.dev"}'),
+	(1700604800, 'chrome', 'go', '{"uid":"a","title":"Go","arg":"https://go.dev","subtitle":"newer"}'),
+	(1700604800, 'chrome', 'rust', '{"uid":"b","title":"Rust","arg":"https://rust-lang.org"}'),
+	(1701209600, 'books', 'go', '{"uid":"a","title":"Go","arg":"https://go.dev"}'),
+	(1701209600, 'chrome', 'oops', '"not an item"');
@@ -0,0 +1,16 @@
+PRAGMA user_version = 1;
+PRAGMA foreign_keys=OFF;
+BEGIN TRANSACTION;
+CREATE TABLE items (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	ts INTEGER NOT NULL,
+	trigger VARCHAR(32) NOT NULL,
+	query VARCHAR(128) NOT NULL,
+	item VARCHAR(1024) NOT NULL);
+INSERT INTO items VALUES(1,1700000000,'chrome','go','{"uid":"a","title":"Go","arg":"https://go.dev"}');
+INSERT INTO items VALUES(2,1700604800,'chrome','go','{"uid":"a","title":"Go","arg":"https://go.dev","subtitle":"newer"}');
+INSERT INTO items VALUES(3,1700604800,'chrome','rust','{"uid":"b","title":"Rust","arg":"https://rust-lang.org"}');
+INSERT INTO items VALUES(4,1701209600,'books','go','{"uid":"a","title":"Go","arg":"https://go.dev"}');
+INSERT INTO items VALUES(5,1701209600,'chrome','oops','"not an item"');
+INSERT INTO sqlite_sequence VALUES('items',5);
+COMMIT;
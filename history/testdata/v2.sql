@@ -0,0 +1,39 @@
+PRAGMA user_version = 2;
+PRAGMA foreign_keys=OFF;
+BEGIN TRANSACTION;
+CREATE TABLE IF NOT EXISTS "selections" (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	ts INTEGER NOT NULL,
+	trigger VARCHAR(32) NOT NULL,
+	query VARCHAR(128) NOT NULL,
+	item VARCHAR(1024) NOT NULL);
+INSERT INTO selections VALUES(1,1700000000,'chrome','go','{"uid":"a","title":"Go","arg":"https://go.dev"}');
+INSERT INTO selections VALUES(2,1700604800,'chrome','go','{"uid":"a","title":"Go","arg":"https://go.dev","subtitle":"newer"}');
+INSERT INTO selections VALUES(3,1700604800,'chrome','rust','{"uid":"b","title":"Rust","arg":"https://rust-lang.org"}');
+INSERT INTO selections VALUES(4,1701209600,'books','go','{"uid":"a","title":"Go","arg":"https://go.dev"}');
+INSERT INTO selections VALUES(5,1701209600,'chrome','oops','"not an item"');
+CREATE TABLE items (
+				id INTEGER PRIMARY KEY,
+				trigger TEXT NOT NULL,
+				key TEXT NOT NULL,
+				item TEXT NOT NULL,
+				count INTEGER NOT NULL,
+				first_used INTEGER NOT NULL,
+				last_used INTEGER NOT NULL,
+				score REAL NOT NULL,
+				UNIQUE (trigger, key));
+INSERT INTO items VALUES(1,'chrome','e96fd5925efa9606c53f05dfe410f4b20a9d0e27b71b021abb5e49493034ab4e','{"uid":"a","title":"Go","arg":"https://go.dev","subtitle":"newer"}',2,1700000000,1700604800,1.5);
+INSERT INTO items VALUES(2,'chrome','3dd29c916bc5897c836b86902a1361d8e8fb0f0fccc19c6f0d907ed2730e60a1','{"uid":"b","title":"Rust","arg":"https://rust-lang.org"}',1,1700604800,1700604800,1.0);
+INSERT INTO items VALUES(3,'books','e96fd5925efa9606c53f05dfe410f4b20a9d0e27b71b021abb5e49493034ab4e','{"uid":"a","title":"Go","arg":"https://go.dev"}',1,1701209600,1701209600,1.0);
+CREATE TABLE queries (
+				item_id INTEGER NOT NULL REFERENCES items (id) ON DELETE CASCADE,
+				query TEXT NOT NULL,
+				count INTEGER NOT NULL,
+				last_used INTEGER NOT NULL,
+				score REAL NOT NULL,
+				PRIMARY KEY (item_id, query));
+INSERT INTO queries VALUES(1,'go',2,1700604800,1.5);
+INSERT INTO queries VALUES(2,'rust',1,1700604800,1.0);
+INSERT INTO queries VALUES(3,'go',1,1701209600,1.0);
+INSERT INTO sqlite_sequence VALUES('selections',5);
+COMMIT;
@@ -0,0 +1,172 @@
+package history
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"unicode"
+
+	"github.com/solodov/org-roam-alfred-items/alfred"
+)
+
+// foldedRunes maps letters with diacritics to their base letters. Lowercase forms are enough
+// because text is lowercased first. The table covers Latin-1, Latin Extended-A, pinyin tones and
+// Vietnamese, other precomposed letters are kept as they are and only match themselves. Decomposed
+// text is folded for any letter since combining marks are dropped.
+var foldedRunes = map[rune]string{}
+
+func init() {
+	for base, letters := range map[string]string{
+		"a":  "àáâãäåāăąǎạảấầẩẫậắằẳẵặ",
+		"ae": "æ",
+		"c":  "çćĉċč",
+		"d":  "ďđð",
+		"e":  "èéêëēĕėęěẹẻẽếềểễệ",
+		"g":  "ĝğġģ",
+		"h":  "ĥħ",
+		"i":  "ìíîïĩīĭįıǐỉị",
+		"j":  "ĵ",
+		"k":  "ķ",
+		"l":  "ĺļľŀł",
+		"n":  "ñńņňŉ",
+		"o":  "òóôõöøōŏőǒơọỏốồổỗộớờởỡợ",
+		"oe": "œ",
+		"r":  "ŕŗř",
+		"s":  "śŝşšș",
+		"ss": "ß",
+		"t":  "ţťŧț",
+		"th": "þ",
+		"u":  "ùúûüũūŭůűųǔǖǘǚǜưụủứừửữự",
+		"w":  "ŵ",
+		"y":  "ýÿŷỳỵỷỹ",
+		"z":  "źżž",
+	} {
+		for _, r := range letters {
+			foldedRunes[r] = base
+		}
+	}
+	// ё is folded to е, the rest of Cyrillic is kept as is.
+	foldedRunes['ё'] = "е"
+}
+
+// tokenize splits text into lowercase words without diacritics. Everything except letters and
+// digits separates words, so query syntax characters are never special.
+func tokenize(text string) (tokens []string) {
+	var b strings.Builder
+	flush := func() {
+		if b.Len() > 0 {
+			tokens = append(tokens, b.String())
+			b.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case foldedRunes[r] != "":
+			b.WriteString(foldedRunes[r])
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		case unicode.Is(unicode.Mn, r):
+			// Combining marks of decomposed text.
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
+
+// itemTerms returns words of the title and of plain queries and hashes of words of hashed queries
+// prefixed with hashedQueryPrefix, separated and surrounded by spaces, see termsCond.
+func itemTerms(title string, queries []string) string {
+	terms := tokenize(title)
+	for _, query := range queries {
+		if hashes, hashed := strings.CutPrefix(query, hashedQueryPrefix); hashed {
+			for _, h := range strings.Fields(hashes) {
+				terms = append(terms, hashedQueryPrefix+h)
+			}
+		} else {
+			terms = append(terms, tokenize(query)...)
+		}
+	}
+	return " " + strings.Join(terms, " ") + " "
+}
+
+// termsCond returns an SQL expression that counts terms that are prefixes of words in the terms
+// column of items or whose hash is a hashed word there, and its arguments.
+func termsCond(terms []string) (string, []any) {
+	if len(terms) == 0 {
+		return "0", nil
+	}
+	conds := make([]string, len(terms))
+	var args []any
+	for i, term := range terms {
+		conds[i] = "(instr(terms, ?) > 0 OR instr(terms, ?) > 0)"
+		args = append(args, " "+term, " "+hashedQueryPrefix+hashToken(term)+" ")
+	}
+	return strings.Join(conds, " + "), args
+}
+
+// indexTerms updates terms of the item, it's called whenever the item or its queries change.
+func indexTerms(tx *sql.Tx, id int64) error {
+	var itemStr string
+	if err := tx.QueryRow(`SELECT item FROM items WHERE id = ?`, id).Scan(&itemStr); err != nil {
+		return err
+	}
+	var item alfred.Item
+	json.Unmarshal([]byte(itemStr), &item)
+	rows, err := tx.Query(`SELECT query FROM queries WHERE item_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	var queries []string
+	for rows.Next() {
+		var query string
+		if err := rows.Scan(&query); err != nil {
+			rows.Close()
+			return err
+		}
+		queries = append(queries, query)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE items SET terms = ? WHERE id = ?`, itemTerms(item.Title, queries), id)
+	return err
+}
+
+// indexMissingTerms indexes items that don't have terms yet, which are items of databases migrated
+// to schema version 5.
+func indexMissingTerms(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(`SELECT id FROM items WHERE terms IS NULL`)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	for _, id := range ids {
+		if err := indexTerms(tx, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
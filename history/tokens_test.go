@@ -0,0 +1,142 @@
+package history
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/solodov/org-roam-alfred-items/alfred"
+)
+
+func TestTokenize(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"  ,.- ", nil},
+		{"Hello, World!", []string{"hello", "world"}},
+		{"Crème Brûlée", []string{"creme", "brulee"}},
+		{"Straße Œuvre Łódź", []string{"strasse", "oeuvre", "lodz"}},
+		// Decomposed e and combining acute accent.
+		{"Café", []string{"cafe"}},
+		{"Phở Hà Nội, Sơn Thủy, Trường", []string{"pho", "ha", "noi", "son", "thuy", "truong"}},
+		{"Ёлка и ЕЛЬ", []string{"елка", "и", "ель"}},
+		{"go/doc:v1.20 (site:go.dev) \"quoted\" -minus", []string{"go", "doc", "v1", "20", "site", "go", "dev", "quoted", "minus"}},
+		{"東京タワー 東京・大阪", []string{"東京タワー", "東京", "大阪"}},
+	} {
+		if got := tokenize(tc.text); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+}
+
+// setupHistory points the package at an empty database in a temp dir with default settings.
+func setupHistory(t *testing.T) {
+	t.Helper()
+	Path = filepath.Join(t.TempDir(), "history.db")
+	savedPrivacy, savedRetention, savedAnyTerm := Privacy, Retention, AnyTerm
+	t.Cleanup(func() { Privacy, Retention, AnyTerm = savedPrivacy, savedRetention, savedAnyTerm })
+	Privacy, Retention, AnyTerm = PrivacyRules{}, PruneOptions{}, false
+}
+
+func addItem(t *testing.T, trigger, query string, item alfred.Item, ts int64) {
+	t.Helper()
+	data, err := json.Marshal(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Add(trigger, query, string(data), ts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// matchingUids returns uids of items FindMatchingItems suggests for the query, sorted.
+func matchingUids(triggers []string, query string) (uids []string) {
+	for _, item := range FindMatchingItems(triggers, query) {
+		uids = append(uids, item.Uid)
+	}
+	sort.Strings(uids)
+	return uids
+}
+
+func TestFindMatchingItems(t *testing.T) {
+	setupHistory(t)
+	now := time.Now().Unix()
+	addItem(t, "chrome", "", alfred.Item{Uid: "creme", Title: "Crème brûlée recipe", Arg: "https://a"}, now)
+	addItem(t, "chrome", "dessert", alfred.Item{Uid: "creme", Title: "Crème brûlée recipe", Arg: "https://a"}, now)
+	addItem(t, "chrome", "", alfred.Item{Uid: "pho", Title: "Phở Hà Nội", Arg: "https://b"}, now)
+	addItem(t, "chrome", "", alfred.Item{Uid: "go", Title: "go/doc: package (docs)", Arg: "https://c"}, now)
+	addItem(t, "chrome", "", alfred.Item{Uid: "tokyo", Title: "東京タワー", Arg: "https://d"}, now)
+	addItem(t, "books", "", alfred.Item{Uid: "book", Title: "Crème de la crème", Arg: "https://e"}, now)
+	for _, tc := range []struct {
+		query    string
+		anyTerm  bool
+		triggers []string
+		want     []string
+	}{
+		// Queries without words suggest recently used items.
+		{"", false, []string{"chrome"}, []string{"creme", "go", "pho", "tokyo"}},
+		{"   ", false, []string{"chrome"}, []string{"creme", "go", "pho", "tokyo"}},
+		{"%", false, []string{"books"}, []string{"book"}},
+		{"creme", false, []string{"chrome"}, []string{"creme"}},
+		{"CRÈME", false, nil, []string{"book", "creme"}},
+		{"bru rec", false, []string{"chrome"}, []string{"creme"}},
+		{"rec bru", false, []string{"chrome"}, []string{"creme"}},
+		// Queries an item was selected with match too.
+		{"dess", false, []string{"chrome"}, []string{"creme"}},
+		{"ecipe", false, []string{"chrome"}, nil},
+		{"pho noi", false, []string{"chrome"}, []string{"pho"}},
+		{"(docs", false, []string{"chrome"}, []string{"go"}},
+		{"go/doc:", false, []string{"chrome"}, []string{"go"}},
+		{"'quoted\" OR 1=1 --", false, []string{"chrome"}, nil},
+		// CJK runs are single words, only their beginning matches.
+		{"東京", false, []string{"chrome"}, []string{"tokyo"}},
+		{"タワー", false, []string{"chrome"}, nil},
+		{"creme pho", false, []string{"chrome"}, nil},
+		{"creme pho", true, []string{"chrome"}, []string{"creme", "pho"}},
+		{"creme missing", true, []string{"chrome"}, []string{"creme"}},
+	} {
+		AnyTerm = tc.anyTerm
+		if got := matchingUids(tc.triggers, tc.query); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("FindMatchingItems(%q, %q) with AnyTerm %v = %q, want %q", tc.triggers, tc.query, tc.anyTerm, got, tc.want)
+		}
+	}
+}
+
+func TestFindMatchingItemsOrder(t *testing.T) {
+	setupHistory(t)
+	now := time.Now().Unix()
+	addItem(t, "t", "", alfred.Item{Uid: "both", Title: "alpha beta", Arg: "1"}, now-100)
+	addItem(t, "t", "", alfred.Item{Uid: "old", Title: "alpha", Arg: "2"}, now-50)
+	addItem(t, "t", "", alfred.Item{Uid: "new", Title: "alpha", Arg: "3"}, now)
+	AnyTerm = true
+	var got []string
+	for _, item := range FindMatchingItems(nil, "alpha beta") {
+		got = append(got, item.Uid)
+	}
+	if want := []string{"both", "new", "old"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FindMatchingItems() = %q, want %q", got, want)
+	}
+}
+
+func TestFindMatchingItemsHashed(t *testing.T) {
+	setupHistory(t)
+	Privacy.HashQueries = true
+	now := time.Now().Unix()
+	addItem(t, "chrome", "Secret Plans", alfred.Item{Uid: "doc", Title: "Quarterly report", Arg: "https://a"}, now)
+	for query, want := range map[string][]string{
+		"secret":       {"doc"},
+		"SECRET plans": {"doc"},
+		"quart":        {"doc"},
+		// Hashed words only match whole.
+		"secr": nil,
+	} {
+		if got := matchingUids(nil, query); !reflect.DeepEqual(got, want) {
+			t.Errorf("FindMatchingItems(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Copyright © 2023 Peter Solodov <solodov@gmail.com>
+*/
+package match
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Scoring constants, loosely modeled after fzf. Every matched character is worth scoreMatch,
+// characters at word boundaries and runs of consecutive characters get bonuses, gaps between
+// matched characters are penalized.
+const (
+	scoreMatch       = 16
+	bonusBoundary    = 10
+	bonusCamel       = 7
+	bonusConsecutive = 8
+	bonusFirstChar   = 2 // multiplier for the boundary bonus of the first character of a term
+	bonusPrimary     = 4
+	penaltyGapStart  = 3
+	penaltyGapExtend = 1
+	// lengthDivisor makes shorter texts win ties, every lengthDivisor runes cost a point.
+	lengthDivisor = 8
+)
+
+type Result struct {
+	Score int
+	// Positions are sorted rune indexes of matched characters in the text.
+	Positions []int
+}
+
+// Matcher matches every whitespace-separated term of a query against texts, case-insensitively
+// and with arbitrary gaps between term characters.
+type Matcher struct {
+	terms [][]rune
+}
+
+func New(query string) *Matcher {
+	m := &Matcher{}
+	for _, term := range strings.Fields(query) {
+		m.terms = append(m.terms, []rune(strings.ToLower(term)))
+	}
+	return m
+}
+
+func (m *Matcher) Empty() bool {
+	return len(m.terms) == 0
+}
+
+// Match scores the text, ok is false if any of the terms doesn't match. Empty query matches
+// everything with zero score.
+func (m *Matcher) Match(text string) (Result, bool) {
+	return m.MatchPrimary(text, len(text))
+}
+
+// MatchPrimary is like Match but characters matched at or after byte offset primary get an extra
+// bonus. It's meant for texts that end with the most important part, like a node title that
+// follows its outline path.
+func (m *Matcher) MatchPrimary(text string, primary int) (r Result, ok bool) {
+	return m.MatchKey(text, strings.ToLower(text), primary)
+}
+
+// MatchKey is like MatchPrimary for callers that keep the lower-cased text around, key must be
+// strings.ToLower(text).
+func (m *Matcher) MatchKey(text, key string, primary int) (r Result, ok bool) {
+	if m.Empty() {
+		return r, true
+	}
+	for _, term := range m.terms {
+		if !subsequence(term, key) {
+			return Result{}, false
+		}
+	}
+	runes, lower := []rune(text), []rune(key)
+	if len(runes) != len(lower) {
+		// Lower-casing changed the number of runes, fall back to positions in the key.
+		runes = lower
+	}
+	if primary > len(text) {
+		primary = len(text)
+	}
+	primaryRune := len([]rune(text[:primary]))
+	for _, term := range m.terms {
+		positions, found := locate(term, lower)
+		if !found {
+			return Result{}, false
+		}
+		r.Score += score(positions, runes, primaryRune)
+		r.Positions = append(r.Positions, positions...)
+	}
+	r.Score -= len(runes) / lengthDivisor
+	sort.Ints(r.Positions)
+	return r, true
+}
+
+// subsequence is a cheap check that the term characters occur in the key in order, it avoids
+// allocations for the vast majority of texts that don't match.
+func subsequence(term []rune, key string) bool {
+	ti := 0
+	for _, c := range key {
+		if c == term[ti] {
+			ti++
+			if ti == len(term) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// locate finds the term in text using a forward scan for the earliest match end followed by a
+// backward scan that picks the shortest match ending there.
+func locate(term, text []rune) (positions []int, found bool) {
+	ti, end := 0, -1
+	for i, c := range text {
+		if c == term[ti] {
+			ti++
+			if ti == len(term) {
+				end = i
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return nil, false
+	}
+	positions = make([]int, len(term))
+	ti = len(term) - 1
+	for i := end; i >= 0 && ti >= 0; i-- {
+		if text[i] == term[ti] {
+			positions[ti] = i
+			ti--
+		}
+	}
+	return positions, true
+}
+
+func score(positions []int, text []rune, primary int) (s int) {
+	for i, pos := range positions {
+		s += scoreMatch
+		bonus := charBonus(text, pos)
+		if i == 0 {
+			bonus *= bonusFirstChar
+		} else if gap := pos - positions[i-1] - 1; gap == 0 {
+			if bonus < bonusConsecutive {
+				bonus = bonusConsecutive
+			}
+		} else {
+			s -= penaltyGapStart + (gap-1)*penaltyGapExtend
+		}
+		s += bonus
+		if pos >= primary {
+			s += bonusPrimary
+		}
+	}
+	return s
+}
+
+func charBonus(text []rune, pos int) int {
+	if pos == 0 {
+		return bonusBoundary
+	}
+	prev, cur := text[pos-1], text[pos]
+	if !unicode.IsLetter(prev) && !unicode.IsDigit(prev) {
+		return bonusBoundary
+	}
+	if unicode.IsLower(prev) && unicode.IsUpper(cur) {
+		return bonusCamel
+	}
+	return 0
+}
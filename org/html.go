@@ -0,0 +1,210 @@
+package org
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Options control rendering of parsed documents.
+type Options struct {
+	// ResolveLink returns the URL for a link target. Empty result renders the link description
+	// without a link. Nil ResolveLink keeps web and mailto links and drops the rest.
+	ResolveLink func(target string) string
+	// DescribeLink returns the description of a link target for links without one. Empty result,
+	// or nil DescribeLink, uses the target.
+	DescribeLink func(target string) string
+}
+
+func (o Options) describe(target string) string {
+	if o.DescribeLink != nil {
+		if desc := o.DescribeLink(target); desc != "" {
+			return desc
+		}
+	}
+	return target
+}
+
+func (o Options) resolve(target string) string {
+	if o.ResolveLink != nil {
+		return o.ResolveLink(target)
+	}
+	for _, scheme := range []string{"http:", "https:", "mailto:"} {
+		if strings.HasPrefix(target, scheme) {
+			return target
+		}
+	}
+	return ""
+}
+
+// minLevel returns the level of the topmost heading so rendered subtrees start at the first
+// heading level of the output format.
+func minLevel(blocks []Block) int {
+	level := -1
+	for _, b := range blocks {
+		if b.Kind == Heading && (level < 0 || b.Level < level) {
+			level = b.Level
+		}
+	}
+	return level
+}
+
+// HTML renders blocks as an HTML fragment.
+func HTML(blocks []Block, opts Options) string {
+	var b strings.Builder
+	writeHTMLBlocks(&b, blocks, minLevel(blocks), opts)
+	return b.String()
+}
+
+func writeHTMLBlocks(b *strings.Builder, blocks []Block, base int, opts Options) {
+	for _, block := range blocks {
+		switch block.Kind {
+		case Heading:
+			level := block.Level - base + 1
+			if level > 6 {
+				level = 6
+			}
+			fmt.Fprintf(b, "<h%d>", level)
+			if block.Todo != "" {
+				fmt.Fprintf(b, `<span class="todo">%s</span> `, html.EscapeString(block.Todo))
+			}
+			b.WriteString(inlineHTML(ParseInline(block.Text), opts))
+			if len(block.Tags) > 0 {
+				fmt.Fprintf(b, ` <span class="tags">%s</span>`, html.EscapeString(strings.Join(block.Tags, " ")))
+			}
+			fmt.Fprintf(b, "</h%d>\n", level)
+		case Paragraph:
+			fmt.Fprintf(b, "<p>%s</p>\n", inlineHTML(ParseInline(block.Text), opts))
+		case List:
+			writeHTMLList(b, block, base, opts)
+		case Src:
+			class := ""
+			if block.Lang != "" {
+				class = fmt.Sprintf(` class="language-%s"`, html.EscapeString(block.Lang))
+			}
+			fmt.Fprintf(b, "<pre><code%s>%s</code></pre>\n", class, html.EscapeString(block.Text))
+		case Example:
+			fmt.Fprintf(b, "<pre>%s</pre>\n", html.EscapeString(block.Text))
+		case Quote:
+			b.WriteString("<blockquote>\n")
+			writeHTMLBlocks(b, block.Children, base, opts)
+			b.WriteString("</blockquote>\n")
+		case Table:
+			writeHTMLTable(b, block, opts)
+		case Rule:
+			b.WriteString("<hr>\n")
+		}
+	}
+}
+
+func writeHTMLList(b *strings.Builder, list Block, base int, opts Options) {
+	tag := "ul"
+	if list.Ordered {
+		tag = "ol"
+	} else if len(list.Items) > 0 && list.Items[0].Term != "" {
+		tag = "dl"
+	}
+	fmt.Fprintf(b, "<%s>\n", tag)
+	for _, item := range list.Items {
+		if tag == "dl" {
+			fmt.Fprintf(b, "<dt>%s</dt><dd>", inlineHTML(ParseInline(item.Term), opts))
+		} else {
+			b.WriteString("<li>")
+		}
+		if tag != "dl" && item.Term != "" {
+			fmt.Fprintf(b, "<b>%s</b>: ", inlineHTML(ParseInline(item.Term), opts))
+		}
+		switch item.Checkbox {
+		case "X":
+			b.WriteString("&#9745; ")
+		case " ":
+			b.WriteString("&#9744; ")
+		case "-":
+			b.WriteString("&#9635; ")
+		}
+		b.WriteString(inlineHTML(ParseInline(item.Text), opts))
+		if len(item.Children) > 0 {
+			b.WriteString("\n")
+			writeHTMLBlocks(b, item.Children, base, opts)
+		}
+		if tag == "dl" {
+			b.WriteString("</dd>\n")
+		} else {
+			b.WriteString("</li>\n")
+		}
+	}
+	fmt.Fprintf(b, "</%s>\n", tag)
+}
+
+func writeHTMLTable(b *strings.Builder, table Block, opts Options) {
+	b.WriteString("<table>\n")
+	// A separator after the first row makes it a header.
+	header := len(table.Rows) > 1 && table.Rows[0] != nil && table.Rows[1] == nil
+	for i, row := range table.Rows {
+		if row == nil {
+			continue
+		}
+		cell := "td"
+		if header && i == 0 {
+			cell = "th"
+		}
+		b.WriteString("<tr>")
+		for _, c := range row {
+			fmt.Fprintf(b, "<%s>%s</%s>", cell, inlineHTML(ParseInline(c), opts), cell)
+		}
+		b.WriteString("</tr>\n")
+	}
+	b.WriteString("</table>\n")
+}
+
+func inlineHTML(inlines []Inline, opts Options) string {
+	var b strings.Builder
+	tags := map[InlineKind]string{Bold: "b", Italic: "i", Underline: "u", Strike: "del"}
+	for _, in := range inlines {
+		switch in.Kind {
+		case Text:
+			b.WriteString(html.EscapeString(in.Text))
+		case Verbatim, Code:
+			fmt.Fprintf(&b, "<code>%s</code>", html.EscapeString(in.Text))
+		case Timestamp:
+			fmt.Fprintf(&b, `<span class="timestamp">%s</span>`, html.EscapeString(in.Text))
+		case Link:
+			desc := html.EscapeString(opts.describe(in.Target))
+			if len(in.Children) > 0 {
+				desc = inlineHTML(in.Children, opts)
+			}
+			if href := opts.resolve(in.Target); href != "" {
+				fmt.Fprintf(&b, `<a href="%s">%s</a>`, html.EscapeString(href), desc)
+			} else {
+				b.WriteString(desc)
+			}
+		default:
+			fmt.Fprintf(&b, "<%s>%s</%s>", tags[in.Kind], inlineHTML(in.Children, opts), tags[in.Kind])
+		}
+	}
+	return b.String()
+}
+
+// HTMLPage wraps an HTML fragment into a standalone page with minimal styling.
+func HTMLPage(title, body string) string {
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: -apple-system, sans-serif; max-width: 50em; margin: 1em auto; padding: 0 1em; line-height: 1.4; }
+pre { background: #f4f4f4; padding: 0.5em; overflow-x: auto; }
+code { font-family: Menlo, monospace; font-size: 90%%; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.5em; }
+.todo { color: #b22; font-size: 80%%; }
+.tags, .timestamp { color: #888; font-size: 80%%; }
+blockquote { border-left: 3px solid #ccc; margin-left: 0; padding-left: 1em; color: #555; }
+</style>
+</head>
+<body>
+%s</body>
+</html>
+`, html.EscapeString(title), body)
+}
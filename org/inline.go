@@ -0,0 +1,132 @@
+package org
+
+import (
+	"strings"
+)
+
+type InlineKind int
+
+const (
+	Text InlineKind = iota
+	Bold
+	Italic
+	Underline
+	Verbatim
+	Code
+	Strike
+	Link
+	Timestamp
+)
+
+// Inline is a piece of markup inside paragraphs, headings, list items and table cells.
+type Inline struct {
+	Kind InlineKind
+	// Text of plain text, verbatim, code and timestamps.
+	Text string
+	// Target of a link, like "id:..." or "https://...".
+	Target string
+	// Children of emphasis and link descriptions. Links without a description have no children.
+	Children []Inline
+}
+
+var emphasisKinds = map[byte]InlineKind{
+	'*': Bold,
+	'/': Italic,
+	'_': Underline,
+	'=': Verbatim,
+	'~': Code,
+	'+': Strike,
+}
+
+// ParseInline splits text into inline markup.
+func ParseInline(text string) (inlines []Inline) {
+	var plain strings.Builder
+	flush := func() {
+		if plain.Len() > 0 {
+			inlines = append(inlines, Inline{Kind: Text, Text: plain.String()})
+			plain.Reset()
+		}
+	}
+	for i := 0; i < len(text); {
+		if strings.HasPrefix(text[i:], "[[") {
+			if groups := linkRe.FindStringSubmatch(text[i:]); len(groups) > 0 {
+				flush()
+				link := Inline{Kind: Link, Target: groups[1]}
+				if groups[2] != "" {
+					link.Children = ParseInline(groups[2])
+				}
+				inlines = append(inlines, link)
+				i += len(groups[0])
+				continue
+			}
+		}
+		if text[i] == '<' || text[i] == '[' {
+			if ts := timestampRe.FindString(text[i:]); ts != "" {
+				flush()
+				inlines = append(inlines, Inline{Kind: Timestamp, Text: ts})
+				i += len(ts)
+				continue
+			}
+		}
+		if kind, isMarker := emphasisKinds[text[i]]; isMarker && (i == 0 || strings.IndexByte(emphasisPre, text[i-1]) >= 0) {
+			if end := emphasisEnd(text, i); end > 0 {
+				flush()
+				content := text[i+1 : end]
+				if kind == Verbatim || kind == Code {
+					inlines = append(inlines, Inline{Kind: kind, Text: content})
+				} else {
+					inlines = append(inlines, Inline{Kind: kind, Children: ParseInline(content)})
+				}
+				i = end + 1
+				continue
+			}
+		}
+		plain.WriteByte(text[i])
+		i++
+	}
+	flush()
+	return inlines
+}
+
+const (
+	emphasisPre  = " \t-({'\""
+	emphasisPost = " \t-.,:;!?')}\"\\["
+)
+
+// emphasisEnd returns the index of the marker that closes emphasis opened at start, or -1.
+func emphasisEnd(text string, start int) int {
+	marker := text[start]
+	if start+1 >= len(text) || text[start+1] == ' ' || text[start+1] == marker {
+		return -1
+	}
+	for end := start + 2; end < len(text); end++ {
+		if text[end] == '\n' {
+			return -1
+		}
+		if text[end] == marker && text[end-1] != ' ' &&
+			(end+1 == len(text) || strings.IndexByte(emphasisPost, text[end+1]) >= 0) {
+			return end
+		}
+	}
+	return -1
+}
+
+// PlainText returns inline markup without formatting, links are replaced with their descriptions.
+func PlainText(inlines []Inline) string {
+	var b strings.Builder
+	for _, in := range inlines {
+		switch in.Kind {
+		case Text, Verbatim, Code, Timestamp:
+			b.WriteString(in.Text)
+		case Link:
+			if len(in.Children) > 0 {
+				b.WriteString(PlainText(in.Children))
+			} else {
+				b.WriteString(in.Target)
+			}
+		default:
+			b.WriteString(PlainText(in.Children))
+		}
+	}
+	return b.String()
+}
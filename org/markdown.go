@@ -0,0 +1,207 @@
+package org
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Markdown renders blocks as GitHub flavored Markdown.
+func Markdown(blocks []Block, opts Options) string {
+	var b strings.Builder
+	writeMarkdownBlocks(&b, blocks, minLevel(blocks), opts)
+	return strings.TrimRight(b.String(), "\n") + "\n"
+}
+
+func writeMarkdownBlocks(b *strings.Builder, blocks []Block, base int, opts Options) {
+	for _, block := range blocks {
+		switch block.Kind {
+		case Heading:
+			level := block.Level - base + 1
+			if level > 6 {
+				level = 6
+			}
+			b.WriteString(strings.Repeat("#", level) + " ")
+			if block.Todo != "" {
+				b.WriteString(block.Todo + " ")
+			}
+			b.WriteString(inlineMarkdown(ParseInline(block.Text), opts) + "\n\n")
+		case Paragraph:
+			b.WriteString(escapeLineStarts(inlineMarkdown(ParseInline(block.Text), opts)) + "\n\n")
+		case List:
+			writeMarkdownList(b, block, base, opts)
+			b.WriteString("\n")
+		case Src:
+			fence := codeFence(block.Text, '`', 3)
+			fmt.Fprintf(b, "%s%s\n%s\n%s\n\n", fence, block.Lang, block.Text, fence)
+		case Example:
+			fence := codeFence(block.Text, '`', 3)
+			fmt.Fprintf(b, "%s\n%s\n%s\n\n", fence, block.Text, fence)
+		case Quote:
+			var quote strings.Builder
+			writeMarkdownBlocks(&quote, block.Children, base, opts)
+			for _, line := range strings.Split(strings.TrimRight(quote.String(), "\n"), "\n") {
+				b.WriteString(strings.TrimRight("> "+line, " ") + "\n")
+			}
+			b.WriteString("\n")
+		case Table:
+			writeMarkdownTable(b, block, opts)
+			b.WriteString("\n")
+		case Rule:
+			b.WriteString("---\n\n")
+		}
+	}
+}
+
+// writeMarkdownList writes a tight list, children are indented to the item text so they stay
+// inside the item.
+func writeMarkdownList(b *strings.Builder, list Block, base int, opts Options) {
+	for i, item := range list.Items {
+		bullet := "- "
+		if list.Ordered {
+			bullet = strconv.Itoa(i+1) + ". "
+		}
+		b.WriteString(bullet)
+		switch item.Checkbox {
+		case "X":
+			b.WriteString("[x] ")
+		case " ", "-":
+			// Markdown has no partially done checkboxes.
+			b.WriteString("[ ] ")
+		}
+		if item.Term != "" {
+			b.WriteString("**" + inlineMarkdown(ParseInline(item.Term), opts) + "**: ")
+		}
+		b.WriteString(escapeLineStarts(inlineMarkdown(ParseInline(item.Text), opts)) + "\n")
+		if len(item.Children) == 0 {
+			continue
+		}
+		var children strings.Builder
+		for _, child := range item.Children {
+			if child.Kind == List {
+				writeMarkdownList(&children, child, base, opts)
+			} else {
+				children.WriteString("\n")
+				writeMarkdownBlocks(&children, []Block{child}, base, opts)
+			}
+		}
+		indent := strings.Repeat(" ", len(bullet))
+		for _, line := range strings.Split(strings.TrimRight(children.String(), "\n"), "\n") {
+			if line != "" {
+				line = indent + line
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+}
+
+func writeMarkdownTable(b *strings.Builder, table Block, opts Options) {
+	var rows [][]string
+	width := 0
+	for _, row := range table.Rows {
+		if row == nil {
+			continue
+		}
+		cells := make([]string, len(row))
+		for i, c := range row {
+			cells[i] = strings.ReplaceAll(inlineMarkdown(ParseInline(c), opts), "|", `\|`)
+		}
+		rows = append(rows, cells)
+		if len(cells) > width {
+			width = len(cells)
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+	// Markdown tables need a header, tables without one get an empty header row.
+	header := len(table.Rows) > 1 && table.Rows[0] != nil && table.Rows[1] == nil
+	if !header {
+		rows = append([][]string{nil}, rows...)
+	}
+	for i, row := range rows {
+		for len(row) < width {
+			row = append(row, "")
+		}
+		b.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		if i == 0 {
+			b.WriteString(strings.Repeat("| --- ", width) + "|\n")
+		}
+	}
+}
+
+func inlineMarkdown(inlines []Inline, opts Options) string {
+	var b strings.Builder
+	markers := map[InlineKind][2]string{
+		Bold:      {"**", "**"},
+		Italic:    {"*", "*"},
+		Underline: {"<u>", "</u>"},
+		Strike:    {"~~", "~~"},
+	}
+	for _, in := range inlines {
+		switch in.Kind {
+		case Text:
+			b.WriteString(markdownEscaper.Replace(in.Text))
+		case Verbatim, Code:
+			fence := codeFence(in.Text, '`', 1)
+			if strings.HasPrefix(in.Text, "`") || strings.HasSuffix(in.Text, "`") {
+				b.WriteString(fence + " " + in.Text + " " + fence)
+			} else {
+				b.WriteString(fence + in.Text + fence)
+			}
+		case Timestamp:
+			// Angle brackets would be taken for HTML or autolinks.
+			ts := strings.ReplaceAll(strings.Trim(in.Text, "<>[]"), "]--[", "–")
+			b.WriteString(markdownEscaper.Replace(strings.ReplaceAll(ts, ">--<", "–")))
+		case Link:
+			desc := markdownEscaper.Replace(opts.describe(in.Target))
+			if len(in.Children) > 0 {
+				desc = inlineMarkdown(in.Children, opts)
+			}
+			if href := opts.resolve(in.Target); href != "" {
+				if strings.ContainsAny(href, " ()<>") {
+					href = "<" + strings.NewReplacer("<", "%3C", ">", "%3E").Replace(href) + ">"
+				}
+				fmt.Fprintf(&b, "[%s](%s)", desc, href)
+			} else {
+				b.WriteString(desc)
+			}
+		default:
+			m := markers[in.Kind]
+			b.WriteString(m[0] + inlineMarkdown(in.Children, opts) + m[1])
+		}
+	}
+	return b.String()
+}
+
+// escapeLineStarts escapes characters that would turn lines of text into Markdown headings, quotes,
+// lists, setext underlines or fences.
+func escapeLineStarts(text string) string {
+	return blockStartRe.ReplaceAllStringFunc(text, func(start string) string {
+		last := len(start) - 1
+		return start[:last] + `\` + start[last:]
+	})
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`)
+
+// codeFence returns a run of marker characters longer than any run in text and at least minRun
+// long.
+func codeFence(text string, marker byte, minRun int) string {
+	longest, run := 0, 0
+	for i := 0; i < len(text); i++ {
+		if text[i] == marker {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	if longest+1 > minRun {
+		minRun = longest + 1
+	}
+	return strings.Repeat(string(marker), minRun)
+}
@@ -0,0 +1,345 @@
+/*
+Copyright © 2023 Peter Solodov <solodov@gmail.com>
+*/
+package org
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+type BlockKind int
+
+const (
+	Paragraph BlockKind = iota
+	Heading
+	List
+	Src
+	Example
+	Quote
+	Table
+	Rule
+)
+
+// Block is a top-level element of an org document.
+type Block struct {
+	Kind BlockKind
+	// Level of a heading.
+	Level int
+	Todo  string
+	Tags  []string
+	// Text is the title of a heading, the text of a paragraph and the contents of src and example
+	// blocks.
+	Text string
+	// Lang of a src block.
+	Lang    string
+	Ordered bool
+	Items   []ListItem
+	// Rows of a table, nil rows are separators.
+	Rows [][]string
+	// Children of a quote block.
+	Children []Block
+}
+
+type ListItem struct {
+	// Checkbox is " ", "X" or "-" for items with a checkbox, empty otherwise.
+	Checkbox string
+	// Term of a description list item, "- term :: text".
+	Term string
+	Text string
+	// Children are nested lists and blocks.
+	Children []Block
+}
+
+// Subtree returns the part of the document that belongs to the node at pos, which is the 1-based
+// character position roam stores for nodes. File-level nodes get the whole document, headings get
+// everything up to the next heading of the same or higher level.
+func Subtree(content string, pos int) string {
+	start := 0
+	for i := 1; i < pos && start < len(content); i++ {
+		_, size := utf8.DecodeRuneInString(content[start:])
+		start += size
+	}
+	if start == 0 || start >= len(content) {
+		return content
+	}
+	// Roam points at the beginning of the heading line.
+	start = strings.LastIndexByte(content[:start], '\n') + 1
+	level := headingLevel(content[start:])
+	if level == 0 {
+		return content[start:]
+	}
+	offset := strings.IndexByte(content[start:], '\n')
+	if offset < 0 {
+		return content[start:]
+	}
+	for end := start + offset + 1; end < len(content); {
+		if l := headingLevel(content[end:]); l > 0 && l <= level {
+			return content[start:end]
+		}
+		next := strings.IndexByte(content[end:], '\n')
+		if next < 0 {
+			break
+		}
+		end += next + 1
+	}
+	return content[start:]
+}
+
+func headingLevel(s string) int {
+	level := 0
+	for level < len(s) && s[level] == '*' {
+		level++
+	}
+	if level > 0 && level < len(s) && s[level] == ' ' {
+		return level
+	}
+	return 0
+}
+
+// DefaultTodoKeywords are recognized in headings in addition to keywords from #+todo lines.
+var DefaultTodoKeywords = []string{"TODO", "DONE", "NEXT", "WAIT", "PROG", "PLAN", "IDEA", "DROP", "CANCELED", "CANCELLED"}
+
+type parser struct {
+	lines []string
+	pos   int
+	todo  map[string]bool
+}
+
+// Parse splits the document into blocks. Drawers, keywords and comments are dropped.
+func Parse(text string) []Block {
+	p := &parser{lines: strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n"), todo: map[string]bool{}}
+	for _, kw := range DefaultTodoKeywords {
+		p.todo[kw] = true
+	}
+	for _, line := range p.lines {
+		if groups := todoKeywordRe.FindStringSubmatch(line); len(groups) > 0 {
+			for _, kw := range strings.Fields(groups[1]) {
+				if kw != "|" {
+					// Strip fast access keys and logging settings, "DONE(d!)".
+					kw, _, _ = strings.Cut(kw, "(")
+					p.todo[kw] = true
+				}
+			}
+		}
+	}
+	return p.blocks(-1)
+}
+
+// blocks parses blocks until the end of input or a line that is indented at most by indent, which
+// ends list items. Negative indent parses everything.
+func (p *parser) blocks(indent int) (blocks []Block) {
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		trimmed := strings.TrimSpace(line)
+		if indent >= 0 && trimmed != "" && indentOf(line) <= indent {
+			return blocks
+		}
+		switch {
+		case trimmed == "":
+			p.pos++
+			if indent >= 0 && p.pos < len(p.lines) && strings.TrimSpace(p.lines[p.pos]) == "" {
+				// Two blank lines end a list item.
+				return blocks
+			}
+		case headingLevel(line) > 0:
+			if indent >= 0 {
+				return blocks
+			}
+			blocks = append(blocks, p.heading(line))
+			p.pos++
+		case drawerRe.MatchString(line):
+			p.skipDrawer()
+		case strings.HasPrefix(strings.ToLower(trimmed), "#+begin_"):
+			blocks = append(blocks, p.greaterBlock())
+		case strings.HasPrefix(trimmed, "#+") || trimmed == "#" || strings.HasPrefix(trimmed, "# "):
+			p.pos++
+		case strings.HasPrefix(trimmed, "|"):
+			blocks = append(blocks, p.table())
+		case ruleRe.MatchString(line):
+			blocks = append(blocks, Block{Kind: Rule})
+			p.pos++
+		case trimmed == ":" || strings.HasPrefix(trimmed, ": "):
+			blocks = append(blocks, p.fixedWidth())
+		case listItemRe.MatchString(line) && !strings.HasPrefix(line, "*"):
+			blocks = append(blocks, p.list(indentOf(line)))
+		default:
+			blocks = append(blocks, p.paragraph(indent))
+		}
+	}
+	return blocks
+}
+
+func (p *parser) heading(line string) Block {
+	b := Block{Kind: Heading, Level: headingLevel(line)}
+	title := strings.TrimSpace(line[b.Level:])
+	if kw, rest, _ := strings.Cut(title, " "); p.todo[kw] {
+		b.Todo, title = kw, strings.TrimSpace(rest)
+	}
+	if groups := headingTagsRe.FindStringSubmatch(title); len(groups) > 0 {
+		b.Tags = strings.Split(strings.Trim(groups[1], ":"), ":")
+		title = strings.TrimSpace(strings.TrimSuffix(title, groups[0]))
+	}
+	title = priorityRe.ReplaceAllString(title, "")
+	b.Text = title
+	return b
+}
+
+func (p *parser) skipDrawer() {
+	for p.pos++; p.pos < len(p.lines); p.pos++ {
+		if strings.EqualFold(strings.TrimSpace(p.lines[p.pos]), ":END:") {
+			p.pos++
+			return
+		}
+	}
+}
+
+func (p *parser) greaterBlock() Block {
+	begin := strings.Fields(strings.TrimSpace(p.lines[p.pos]))
+	kind := strings.ToLower(strings.TrimPrefix(strings.ToLower(begin[0]), "#+begin_"))
+	end := "#+end_" + kind
+	var body []string
+	for p.pos++; p.pos < len(p.lines); p.pos++ {
+		if strings.ToLower(strings.TrimSpace(p.lines[p.pos])) == end {
+			p.pos++
+			break
+		}
+		body = append(body, p.lines[p.pos])
+	}
+	body = dedent(body)
+	switch kind {
+	case "src":
+		b := Block{Kind: Src, Text: strings.Join(body, "\n")}
+		if len(begin) > 1 {
+			b.Lang = begin[1]
+		}
+		return b
+	case "quote":
+		return Block{Kind: Quote, Children: Parse(strings.Join(body, "\n"))}
+	default:
+		return Block{Kind: Example, Text: strings.Join(body, "\n")}
+	}
+}
+
+func (p *parser) table() Block {
+	b := Block{Kind: Table}
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := strings.TrimSpace(p.lines[p.pos])
+		if !strings.HasPrefix(line, "|") {
+			break
+		}
+		if strings.HasPrefix(line, "|-") {
+			b.Rows = append(b.Rows, nil)
+			continue
+		}
+		var row []string
+		for _, cell := range strings.Split(strings.Trim(line, "|"), "|") {
+			row = append(row, strings.TrimSpace(cell))
+		}
+		b.Rows = append(b.Rows, row)
+	}
+	return b
+}
+
+func (p *parser) fixedWidth() Block {
+	var body []string
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := strings.TrimSpace(p.lines[p.pos])
+		if line != ":" && !strings.HasPrefix(line, ": ") {
+			break
+		}
+		body = append(body, strings.TrimPrefix(strings.TrimPrefix(line, ":"), " "))
+	}
+	return Block{Kind: Example, Text: strings.Join(body, "\n")}
+}
+
+func (p *parser) list(indent int) Block {
+	b := Block{Kind: List}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		groups := listItemRe.FindStringSubmatch(line)
+		if len(groups) == 0 || indentOf(line) != indent {
+			break
+		}
+		if len(b.Items) == 0 {
+			b.Ordered = groups[2] != "-" && groups[2] != "+" && groups[2] != "*"
+		}
+		item := ListItem{Text: groups[4]}
+		if groups[3] != "" {
+			item.Checkbox = groups[3][1:2]
+		}
+		if term, text, found := strings.Cut(item.Text, " :: "); found && !b.Ordered {
+			item.Term, item.Text = term, text
+		}
+		p.pos++
+		// Continuation lines of the item text.
+		for p.pos < len(p.lines) {
+			next := p.lines[p.pos]
+			if strings.TrimSpace(next) == "" || indentOf(next) <= indent || listItemRe.MatchString(next) ||
+				strings.HasPrefix(strings.TrimSpace(next), "#+") || drawerRe.MatchString(next) {
+				break
+			}
+			item.Text += " " + strings.TrimSpace(next)
+			p.pos++
+		}
+		item.Children = p.blocks(indent)
+		b.Items = append(b.Items, item)
+	}
+	return b
+}
+
+func (p *parser) paragraph(indent int) Block {
+	var text []string
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || headingLevel(line) > 0 || strings.HasPrefix(trimmed, "#+") || strings.HasPrefix(trimmed, "|") ||
+			drawerRe.MatchString(line) || (len(text) > 0 && listItemRe.MatchString(line)) ||
+			(indent >= 0 && indentOf(line) <= indent) {
+			break
+		}
+		text = append(text, trimmed)
+	}
+	return Block{Kind: Paragraph, Text: strings.Join(text, " ")}
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+func dedent(lines []string) []string {
+	common := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if i := indentOf(line); common < 0 || i < common {
+			common = i
+		}
+	}
+	if common <= 0 {
+		return lines
+	}
+	result := make([]string, len(lines))
+	for i, line := range lines {
+		if len(line) >= common {
+			result[i] = line[common:]
+		}
+	}
+	return result
+}
+
+var todoKeywordRe, drawerRe, headingTagsRe, priorityRe, ruleRe, listItemRe, linkRe, timestampRe, blockStartRe *regexp.Regexp
+
+func init() {
+	todoKeywordRe = regexp.MustCompile(`(?i)^#\+(?:todo|seq_todo|typ_todo):\s*(.*)$`)
+	drawerRe = regexp.MustCompile(`^\s*:[\w-]+:\s*$`)
+	headingTagsRe = regexp.MustCompile(`\s+(:[\w@#%:]+:)$`)
+	priorityRe = regexp.MustCompile(`^\[#[A-Z0-9]\]\s*`)
+	ruleRe = regexp.MustCompile(`^\s*-{5,}\s*$`)
+	listItemRe = regexp.MustCompile(`^(\s*)([-+*]|\d+[.)])\s+(\[[ X-]\]\s+)?(.*)$`)
+	linkRe = regexp.MustCompile(`^\[\[([^\]]+)\](?:\[([^\]]+)\])?\]`)
+	timestampRe = regexp.MustCompile(`^[<\[]\d{4}-\d{2}-\d{2}(?: [^\]>\n]*)?[>\]](?:--[<\[]\d{4}-\d{2}-\d{2}(?: [^\]>\n]*)?[>\]])?`)
+	blockStartRe = regexp.MustCompile(`(?m)^( {0,3})([#>+\-=~]|\d+[.)])`)
+}
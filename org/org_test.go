@@ -0,0 +1,80 @@
+package org
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// TestGolden renders every testdata/*.org file and compares the result with the .md, .html and
+// .txt files next to it.
+func TestGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.org"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{
+		ResolveLink: func(target string) string {
+			if id, ok := strings.CutPrefix(target, "id:"); ok {
+				return id + ".html"
+			}
+			return target
+		},
+		DescribeLink: func(target string) string {
+			if target == "id:abc" {
+				return "ABC node"
+			}
+			return ""
+		},
+	}
+	for _, input := range inputs {
+		data, err := os.ReadFile(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks := Parse(string(data))
+		base := strings.TrimSuffix(input, ".org")
+		for ext, got := range map[string]string{
+			".md":   Markdown(blocks, opts),
+			".html": HTML(blocks, opts),
+			".txt":  PlainTextBlocks(blocks),
+		} {
+			golden := base + ext
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("%s differs from the rendered output:\n%s", golden, got)
+			}
+		}
+	}
+}
+
+func TestEscapeLineStarts(t *testing.T) {
+	for _, tc := range []struct{ text, want string }{
+		{"#hashtag", `\#hashtag`},
+		{"1. first", `1\. first`},
+		{"1986) year", `1986\) year`},
+		{"- dash", `\- dash`},
+		{"+ plus", `\+ plus`},
+		{"   ~~~", `   \~~~`},
+		{"text\n---\n==", "text\n\\---\n\\=="},
+		{"    # indented code", "    # indented code"},
+		{"mid # line - 1. text", "mid # line - 1. text"},
+	} {
+		if got := escapeLineStarts(tc.text); got != tc.want {
+			t.Errorf("escapeLineStarts(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+}
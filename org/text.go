@@ -0,0 +1,71 @@
+package org
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PlainTextBlocks renders blocks as plain text without markup.
+func PlainTextBlocks(blocks []Block) string {
+	var b strings.Builder
+	writeTextBlocks(&b, blocks, "")
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func writeTextBlocks(b *strings.Builder, blocks []Block, indent string) {
+	for _, block := range blocks {
+		switch block.Kind {
+		case Heading:
+			b.WriteString(indent)
+			if block.Todo != "" {
+				b.WriteString(block.Todo + " ")
+			}
+			b.WriteString(PlainText(ParseInline(block.Text)) + "\n\n")
+		case Paragraph:
+			b.WriteString(indent + PlainText(ParseInline(block.Text)) + "\n\n")
+		case List:
+			for i, item := range block.Items {
+				bullet := "- "
+				if block.Ordered {
+					bullet = strconv.Itoa(i+1) + ". "
+				}
+				b.WriteString(indent + bullet)
+				if item.Checkbox != "" {
+					b.WriteString("[" + item.Checkbox + "] ")
+				}
+				if item.Term != "" {
+					b.WriteString(PlainText(ParseInline(item.Term)) + ": ")
+				}
+				b.WriteString(PlainText(ParseInline(item.Text)) + "\n")
+				if len(item.Children) > 0 {
+					// Blank lines after the last child would split the list.
+					var children strings.Builder
+					writeTextBlocks(&children, item.Children, indent+"  ")
+					b.WriteString(strings.TrimRight(children.String(), "\n") + "\n")
+				}
+			}
+			b.WriteString("\n")
+		case Src, Example:
+			for _, line := range strings.Split(block.Text, "\n") {
+				b.WriteString(indent + "    " + line + "\n")
+			}
+			b.WriteString("\n")
+		case Quote:
+			writeTextBlocks(b, block.Children, indent+"> ")
+		case Table:
+			for _, row := range block.Rows {
+				if row == nil {
+					continue
+				}
+				cells := make([]string, len(row))
+				for i, c := range row {
+					cells[i] = PlainText(ParseInline(c))
+				}
+				b.WriteString(indent + strings.Join(cells, " | ") + "\n")
+			}
+			b.WriteString("\n")
+		case Rule:
+			b.WriteString(indent + "-----\n\n")
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package roam
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/solodov/org-roam-alfred-items/org"
+)
+
+// FullText is an FTS5 index of node bodies. Every node is a chunk of its file from the node
+// position to the position of the next node in the same file. When the sqlite driver is built
+// without the sqlite_fts5 tag chunks go into a plain table that is searched for substrings.
+type FullText struct {
+	db  *sql.DB
+	fts bool
+}
+
+type FullTextHit struct {
+	Id string
+	// Snippet is a fragment of the body with matches wrapped in SnippetOpen and SnippetClose.
+	Snippet string
+}
+
+const (
+	SnippetOpen  = "«"
+	SnippetClose = "»"
+)
+
+const fullTextSchema = `
+  CREATE TABLE IF NOT EXISTS files (
+    file TEXT PRIMARY KEY,
+    hash TEXT NOT NULL);
+  CREATE VIRTUAL TABLE IF NOT EXISTS chunks USING fts5(
+    node_id UNINDEXED,
+    file UNINDEXED,
+    title,
+    body,
+    tokenize = 'unicode61 remove_diacritics 2');`
+
+const plainTextSchema = `
+  CREATE TABLE IF NOT EXISTS files (
+    file TEXT PRIMARY KEY,
+    hash TEXT NOT NULL);
+  CREATE TABLE IF NOT EXISTS chunks (
+    node_id TEXT NOT NULL,
+    file TEXT NOT NULL,
+    title TEXT NOT NULL,
+    body TEXT NOT NULL);
+  CREATE INDEX IF NOT EXISTS chunks_file ON chunks (file);`
+
+func OpenFullText(path string) (*FullText, error) {
+	return openFullText(path, true)
+}
+
+// openFullText opens the index at path, an existing index keeps its kind. A new one is an FTS5
+// index if fts is set and sqlite supports it.
+func openFullText(path string, fts bool) (*FullText, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	var schema string
+	err = db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'chunks'`).Scan(&schema)
+	if err == nil {
+		fts = strings.Contains(strings.ToLower(schema), "fts5")
+	} else if err != sql.ErrNoRows {
+		db.Close()
+		return nil, err
+	}
+	if fts {
+		_, err = db.Exec(fullTextSchema)
+		if err != nil && strings.Contains(err.Error(), "no such module: fts5") {
+			if schema != "" {
+				db.Close()
+				return nil, fmt.Errorf("%v is an FTS5 index but sqlite is built without FTS5, rebuild with -tags sqlite_fts5 or remove the index", path)
+			}
+			log.Println("sqlite is built without FTS5, falling back to substring search")
+			fts = false
+		}
+	}
+	if !fts {
+		_, err = db.Exec(plainTextSchema)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &FullText{db: db, fts: fts}, nil
+}
+
+func (ft *FullText) Close() error {
+	return ft.db.Close()
+}
+
+// Update reindexes files whose hash in the roam database changed and drops files that are gone.
+func (ft *FullText) Update(roamDb *sql.DB) error {
+	indexed := map[string]string{}
+	rows, err := ft.db.Query(`SELECT file, hash FROM files`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var file, hash string
+		if err := rows.Scan(&file, &hash); err != nil {
+			rows.Close()
+			return err
+		}
+		indexed[file] = hash
+	}
+	rows.Close()
+	current := map[string]string{}
+	rows, err = roamDb.Query(`SELECT file, hash FROM files`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var file, hash string
+		if err := rows.Scan(&file, &hash); err != nil {
+			rows.Close()
+			return err
+		}
+		current[file] = hash
+	}
+	rows.Close()
+	for file := range indexed {
+		if _, found := current[file]; !found {
+			if err := ft.replaceFile(file, "", nil); err != nil {
+				return err
+			}
+		}
+	}
+	for file, hash := range current {
+		if indexed[file] == hash {
+			continue
+		}
+		chunks, err := readChunks(roamDb, file)
+		if err != nil {
+			return err
+		}
+		if err := ft.replaceFile(file, hash, chunks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type chunk struct {
+	id, title, body string
+}
+
+// readChunks splits the file into node chunks, file is the quoted name as stored by roam.
+func readChunks(roamDb *sql.DB, file string) (chunks []chunk, err error) {
+	rows, err := roamDb.Query(`SELECT id, pos, title FROM nodes WHERE file = ? ORDER BY pos`, file)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	type node struct {
+		id, title string
+		pos       int
+	}
+	var nodes []node
+	for rows.Next() {
+		var n node
+		if err := rows.Scan(&n.id, &n.pos, &n.title); err != nil {
+			return nil, err
+		}
+		n.id, n.title = Unquote(n.id), Unquote(n.title)
+		nodes = append(nodes, n)
+	}
+	if err := rows.Err(); err != nil || len(nodes) == 0 {
+		return nil, err
+	}
+	data, err := os.ReadFile(Unquote(file))
+	if err != nil {
+		// The file may be gone before roam noticed, there is nothing to index.
+		return nil, nil
+	}
+	content := string(data)
+	offsets := byteOffsets(content)
+	for i, n := range nodes {
+		start, end := offsetAt(offsets, n.pos), len(content)
+		if i+1 < len(nodes) {
+			end = offsetAt(offsets, nodes[i+1].pos)
+		}
+		body := org.PlainTextBlocks(org.Parse(content[start:end]))
+		chunks = append(chunks, chunk{id: n.id, title: n.title, body: body})
+	}
+	return chunks, nil
+}
+
+// byteOffsets maps 0-based character positions to byte offsets.
+func byteOffsets(s string) []int {
+	offsets := make([]int, 0, utf8.RuneCountInString(s)+1)
+	for i := range s {
+		offsets = append(offsets, i)
+	}
+	return append(offsets, len(s))
+}
+
+// offsetAt converts a 1-based roam position into a byte offset.
+func offsetAt(offsets []int, pos int) int {
+	if pos < 1 {
+		return 0
+	}
+	if pos > len(offsets) {
+		return offsets[len(offsets)-1]
+	}
+	return offsets[pos-1]
+}
+
+// replaceFile swaps chunks of the file in one transaction, empty hash removes the file.
+func (ft *FullText) replaceFile(file, hash string, chunks []chunk) error {
+	tx, err := ft.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM chunks WHERE file = ?`, file); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM files WHERE file = ?`, file); err != nil {
+		return err
+	}
+	if hash != "" {
+		for _, c := range chunks {
+			if _, err := tx.Exec(`INSERT INTO chunks (node_id, file, title, body) VALUES (?, ?, ?, ?)`, c.id, file, c.title, c.body); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`INSERT INTO files (file, hash) VALUES (?, ?)`, file, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Search returns nodes whose title or body contain all query terms, best matches first. FTS5
+// indexes match terms as word prefixes, plain ones as substrings, ignoring case of ASCII letters
+// only.
+func (ft *FullText) Search(query string, limit int) (hits []FullTextHit, err error) {
+	if !ft.fts {
+		return ft.searchPlain(strings.Fields(query), limit)
+	}
+	var terms []string
+	for _, term := range strings.Fields(query) {
+		terms = append(terms, `"`+strings.ReplaceAll(term, `"`, `""`)+`"*`)
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	rows, err := ft.db.Query(`
+		SELECT node_id, snippet(chunks, 3, ?, ?, '…', 12)
+		FROM chunks
+		WHERE chunks MATCH ?
+		ORDER BY rank
+		LIMIT ?`,
+		SnippetOpen, SnippetClose, strings.Join(terms, " "), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hit FullTextHit
+		if err := rows.Scan(&hit.Id, &hit.Snippet); err != nil {
+			return nil, err
+		}
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
+
+// searchPlain scans chunks of a plain index, nodes with all terms in the title go first.
+func (ft *FullText) searchPlain(terms []string, limit int) (hits []FullTextHit, err error) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	var (
+		conds, inTitle []string
+		args           []any
+	)
+	for _, term := range terms {
+		pattern := "%" + likeEscaper.Replace(term) + "%"
+		conds = append(conds, `(title LIKE ? ESCAPE '\' OR body LIKE ? ESCAPE '\')`)
+		inTitle = append(inTitle, `title LIKE ? ESCAPE '\'`)
+		args = append(args, pattern, pattern)
+	}
+	for _, term := range terms {
+		args = append(args, "%"+likeEscaper.Replace(term)+"%")
+	}
+	rows, err := ft.db.Query(`
+		SELECT node_id, body
+		FROM chunks
+		WHERE `+strings.Join(conds, " AND ")+`
+		ORDER BY `+strings.Join(inTitle, " AND ")+` DESC, rowid
+		LIMIT ?`,
+		append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hit FullTextHit
+		var body string
+		if err := rows.Scan(&hit.Id, &body); err != nil {
+			return nil, err
+		}
+		hit.Snippet = snippet(body, terms, 12)
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// snippet returns up to size words of the body around the first word that contains a term, like
+// the FTS5 snippet function.
+func snippet(body string, terms []string, size int) string {
+	words := strings.Fields(body)
+	matches := func(word string) bool {
+		for _, term := range terms {
+			if strings.Contains(strings.ToLower(word), strings.ToLower(term)) {
+				return true
+			}
+		}
+		return false
+	}
+	start := 0
+	for i, word := range words {
+		if matches(word) {
+			start = i - size/4
+			break
+		}
+	}
+	if start < 0 || len(words) <= size {
+		start = 0
+	} else if start > len(words)-size {
+		start = len(words) - size
+	}
+	end := start + size
+	if end > len(words) {
+		end = len(words)
+	}
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i, word := range words[start:end] {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		if matches(word) {
+			word = SnippetOpen + word + SnippetClose
+		}
+		b.WriteString(word)
+	}
+	if end < len(words) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
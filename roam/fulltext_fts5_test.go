@@ -0,0 +1,28 @@
+//go:build sqlite_fts5
+
+package roam
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFullText(t *testing.T) {
+	testFullText(t, true)
+}
+
+func TestFullTextKeepsPlainIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fulltext.db")
+	ft, err := openFullText(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.Close()
+	if ft, err = OpenFullText(path); err != nil {
+		t.Fatal(err)
+	}
+	defer ft.Close()
+	if ft.fts {
+		t.Error("plain index was opened as FTS5")
+	}
+}
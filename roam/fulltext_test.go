@@ -0,0 +1,160 @@
+package roam
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// testRoam is a roam database with org files in a temporary directory.
+type testRoam struct {
+	dir string
+	db  *sql.DB
+}
+
+func newTestRoam(t *testing.T) *testRoam {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "roam.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(`
+		CREATE TABLE files (file TEXT PRIMARY KEY, hash TEXT NOT NULL);
+		CREATE TABLE nodes (id TEXT PRIMARY KEY, file TEXT NOT NULL, pos INTEGER NOT NULL, title TEXT);`); err != nil {
+		t.Fatal(err)
+	}
+	return &testRoam{dir, db}
+}
+
+// write saves the org file and registers its nodes, a node starts at the line with its title.
+func (r *testRoam) write(t *testing.T, name, content string, titles map[string]string) {
+	t.Helper()
+	path := filepath.Join(r.dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := strconv.Quote(path)
+	if _, err := r.db.Exec(`DELETE FROM nodes WHERE file = ?`, file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.db.Exec(`INSERT OR REPLACE INTO files (file, hash) VALUES (?, ?)`, file, strconv.Quote(content)); err != nil {
+		t.Fatal(err)
+	}
+	for id, title := range titles {
+		pos := len([]rune(content[:strings.Index(content, title)]))
+		if strings.HasPrefix(content, title) {
+			pos = 0
+		}
+		if _, err := r.db.Exec(`INSERT INTO nodes (id, file, pos, title) VALUES (?, ?, ?, ?)`,
+			strconv.Quote(id), file, pos+1, strconv.Quote(title)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (r *testRoam) remove(t *testing.T, name string) {
+	t.Helper()
+	file := strconv.Quote(filepath.Join(r.dir, name))
+	if _, err := r.db.Exec(`DELETE FROM files WHERE file = ?; DELETE FROM nodes WHERE file = ?`, file, file); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func searchIds(t *testing.T, ft *FullText, query string) string {
+	t.Helper()
+	hits, err := ft.Search(query, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, hit := range hits {
+		ids = append(ids, hit.Id)
+	}
+	return strings.Join(ids, ",")
+}
+
+// testFullText indexes and searches files with an FTS5 or a plain index.
+func testFullText(t *testing.T, fts bool) {
+	r := newTestRoam(t)
+	r.write(t, "garden.org", "#+title: Garden\n\nTomatoes need *full* sun.\n* Compost\nTurn the compost pile weekly.\n", map[string]string{
+		"garden":  "Garden",
+		"compost": "Compost",
+	})
+	r.write(t, "kitchen.org", "#+title: Kitchen\n\nRoast tomatoes with garlic.\n", map[string]string{"kitchen": "Kitchen"})
+	ft, err := openFullText(filepath.Join(r.dir, "index", "fulltext.db"), fts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ft.Close()
+	if ft.fts != fts {
+		t.Fatalf("index is FTS5: %v, want %v", ft.fts, fts)
+	}
+	if err := ft.Update(r.db); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ query, want string }{
+		{"", ""},
+		{"compost", "compost"},
+		{"tomato", "garden,kitchen"},
+		{"tomatoes garlic", "kitchen"},
+		{"TURN pile", "compost"},
+		{"full sun", "garden"},
+		{"missing", ""},
+	} {
+		got := searchIds(t, ft, tc.query)
+		if tc.query == "tomato" {
+			// Ranking differs between index kinds, only the set of hits matters here.
+			ids := strings.Split(got, ",")
+			if len(ids) == 2 && ids[0] > ids[1] {
+				got = ids[1] + "," + ids[0]
+			}
+		}
+		if got != tc.want {
+			t.Errorf("Search(%q) = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+	hits, err := ft.Search("garlic", 10)
+	if err != nil || len(hits) != 1 || !strings.Contains(hits[0].Snippet, SnippetOpen+"garlic") {
+		t.Errorf("Search() = %+v, %v, want garlic marked in the snippet", hits, err)
+	}
+
+	// Changed files are reindexed and removed ones dropped.
+	r.write(t, "kitchen.org", "#+title: Kitchen\n\nBake bread.\n", map[string]string{"kitchen": "Kitchen"})
+	r.remove(t, "garden.org")
+	if err := ft.Update(r.db); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ query, want string }{
+		{"tomatoes", ""},
+		{"compost", ""},
+		{"bread", "kitchen"},
+	} {
+		if got := searchIds(t, ft, tc.query); got != tc.want {
+			t.Errorf("after update Search(%q) = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	testFullText(t, false)
+}
+
+func TestSnippet(t *testing.T) {
+	for _, tc := range []struct{ body, want string }{
+		{"one two three", "one «two» three"},
+		{"a b c d e f two g h i j", "…f «two» g h i…"},
+		{"a b c d e two", "…b c d e «two»"},
+		{"no match here", "no match here"},
+	} {
+		if got := snippet(tc.body, []string{"TWO"}, 5); got != tc.want {
+			t.Errorf("snippet(%q) = %q, want %q", tc.body, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package roam
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/gob"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// IndexVersion must be bumped whenever IndexEntry or the way it's built changes.
+const IndexVersion = 2
+
+// IndexEntry is a node prepared for listing and matching.
+type IndexEntry struct {
+	Id    string
+	Level int
+	Pos   int
+	File  string
+	// Title is the full title, see Node.FullTitle, Primary is the offset of the node's own title in
+	// it.
+	Title   string
+	Primary int
+	// Name is the title of the node itself.
+	Name string
+	// Key is the lower-cased title, used for matching.
+	Key      string
+	Category string
+	Tags     []string
+	// Hidden explains why the node is excluded from listings, empty for visible nodes.
+	Hidden string
+}
+
+type Index struct {
+	Version int
+	// Stamp identifies the state of the roam database and the exclusion rules the index was built
+	// from.
+	Stamp   string
+	Entries []IndexEntry
+}
+
+// LoadIndex returns the node index of the roam database at dbPath. The index is read from
+// cachePath if it's up to date and rebuilt otherwise. The index is stale when the database
+// modification time or size, IndexVersion or salt change. Salt must change whenever the behavior of
+// exclude changes, exclude returns the reason to hide a node or an empty string.
+func LoadIndex(dbPath, cachePath, salt string, exclude func(*Node) string) (*Index, error) {
+	stamp, err := IndexStamp(dbPath, salt)
+	if err != nil {
+		return nil, err
+	}
+	if index, err := readIndex(cachePath); err == nil && index.Version == IndexVersion && index.Stamp == stamp {
+		return index, nil
+	} else if err != nil && !os.IsNotExist(err) {
+		log.Printf("ignoring broken node index: %v\n", err)
+	}
+	index, err := buildIndex(dbPath, exclude)
+	if err != nil {
+		return nil, err
+	}
+	index.Stamp = stamp
+	if err := writeIndex(cachePath, index); err != nil {
+		log.Printf("failed to save node index: %v\n", err)
+	}
+	return index, nil
+}
+
+// IndexStamp returns the stamp an up to date index of the roam database must have.
+func IndexStamp(dbPath, salt string) (string, error) {
+	info, err := os.Stat(dbPath)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%d:%s", info.ModTime().UnixNano(), info.Size(), salt), nil
+}
+
+func buildIndex(dbPath string, exclude func(*Node) string) (*Index, error) {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	nodes, err := ReadNodes(db)
+	if err != nil {
+		return nil, err
+	}
+	index := &Index{Version: IndexVersion, Entries: make([]IndexEntry, 0, len(nodes))}
+	for i := range nodes {
+		n := &nodes[i]
+		title, primary := n.FullTitle()
+		var tags []string
+		for tag := range n.Props.Tags {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		index.Entries = append(index.Entries, IndexEntry{
+			Id:       n.Id,
+			Level:    n.Level,
+			Pos:      n.Pos,
+			File:     n.File,
+			Title:    title,
+			Primary:  primary,
+			Name:     n.Name(),
+			Key:      strings.ToLower(title),
+			Category: n.Props.Category,
+			Tags:     tags,
+			Hidden:   exclude(n),
+		})
+	}
+	return index, nil
+}
+
+func readIndex(path string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	index := &Index{}
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// writeIndex replaces the index file atomically so concurrent readers never see a partial index.
+func writeIndex(path string, index *Index) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	if err := gob.NewEncoder(w).Encode(index); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,63 @@
+package roam
+
+import (
+	"database/sql"
+)
+
+// Link is an id link between two nodes.
+type Link struct {
+	Source, Dest string
+}
+
+// ReadLinks returns all id links from the roam database.
+func ReadLinks(db *sql.DB) (links []Link, err error) {
+	rows, err := db.Query(`SELECT source, dest FROM links WHERE type = '"id"'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l Link
+		if err := rows.Scan(&l.Source, &l.Dest); err != nil {
+			return nil, err
+		}
+		l.Source, l.Dest = Unquote(l.Source), Unquote(l.Dest)
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// Neighborhood walks links in both directions from start up to depth hops and returns visited
+// node IDs in the order they were reached along with links between them. Nodes for which include
+// returns false are neither visited nor walked through.
+func Neighborhood(links []Link, start string, depth int, include func(id string) bool) (ids []string, edges []Link) {
+	adjacent := map[string][]string{}
+	for _, l := range links {
+		adjacent[l.Source] = append(adjacent[l.Source], l.Dest)
+		adjacent[l.Dest] = append(adjacent[l.Dest], l.Source)
+	}
+	visited := map[string]bool{start: true}
+	ids = []string{start}
+	frontier := []string{start}
+	for d := 0; d < depth && len(frontier) > 0; d++ {
+		var next []string
+		for _, id := range frontier {
+			for _, other := range adjacent[id] {
+				if !visited[other] && include(other) {
+					visited[other] = true
+					ids = append(ids, other)
+					next = append(next, other)
+				}
+			}
+		}
+		frontier = next
+	}
+	seen := map[Link]bool{}
+	for _, l := range links {
+		if visited[l.Source] && visited[l.Dest] && !seen[l] {
+			seen[l] = true
+			edges = append(edges, l)
+		}
+	}
+	return ids, edges
+}
@@ -0,0 +1,103 @@
+package roam
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Node is a row of the roam nodes table joined with its file.
+type Node struct {
+	Id        string
+	Level     int
+	Pos       int
+	File      string
+	FileTitle string
+	Title     string
+	Olp       []string
+	Props     Props
+}
+
+// Unquote decodes strings that roam stores as printed elisp strings.
+func Unquote(s string) string {
+	s, _ = strconv.Unquote(s)
+	return strings.ReplaceAll(s, `\"`, `"`)
+}
+
+// ReadNodes returns all nodes from the roam database.
+func ReadNodes(db *sql.DB) (nodes []Node, err error) {
+	rows, err := db.Query(`
+		SELECT nodes.id, nodes.level, nodes.pos, nodes.file, nodes.properties, files.title, nodes.title, nodes.olp
+		FROM nodes
+		INNER JOIN files ON nodes.file = files.file`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			n   Node
+			olp sql.NullString
+		)
+		if err := rows.Scan(&n.Id, &n.Level, &n.Pos, &n.File, &n.Props, &n.FileTitle, &n.Title, &olp); err != nil {
+			return nil, err
+		}
+		for _, s := range []*string{&n.Id, &n.File, &n.FileTitle, &n.Title} {
+			*s = Unquote(*s)
+		}
+		if olp.Valid {
+			for _, groups := range olpRe.FindAllStringSubmatch(olp.String, -1) {
+				n.Olp = append(n.Olp, groups[1])
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, rows.Err()
+}
+
+// Name returns the title of the node itself, which is the file title for file nodes.
+func (n *Node) Name() string {
+	if n.Level == 0 {
+		return n.FileTitle
+	}
+	return n.Title
+}
+
+// FullTitle returns the title shown in node lists and the byte offset in it where the title of the
+// node itself starts.
+func (n *Node) FullTitle() (string, int) {
+	var titleBuilder strings.Builder
+	if n.Props.Category != "" {
+		fmt.Fprint(&titleBuilder, n.Props.Category, ": ")
+	}
+	primary := titleBuilder.Len()
+	fmt.Fprint(&titleBuilder, n.FileTitle)
+	if n.Level > 0 {
+		fmt.Fprint(&titleBuilder, " > ")
+		for _, heading := range n.Olp {
+			fmt.Fprint(&titleBuilder, heading, " > ")
+		}
+		primary = titleBuilder.Len()
+		fmt.Fprint(&titleBuilder, n.Title)
+	}
+	fmt.Fprint(&titleBuilder, n.Props.Tags)
+	return titleBuilder.String(), primary
+}
+
+// ReadFiles returns paths of all files in the roam database.
+func ReadFiles(db *sql.DB) (files []string, err error) {
+	rows, err := db.Query(`SELECT file FROM files`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var file string
+		if err := rows.Scan(&file); err != nil {
+			return nil, err
+		}
+		files = append(files, Unquote(file))
+	}
+	return files, rows.Err()
+}
@@ -39,7 +39,10 @@ type Props struct {
 	Icon            string
 	BrowserOverride string
 	NewWindow       string
+	Keyword         string
 	Tags            Tags
+	// All holds every simple property of the node, including the ones above.
+	All map[string]string
 }
 
 func (props *Props) ItemLinkData() (data struct{ Url, Title string }, err error) {
@@ -53,9 +56,9 @@ func (props *Props) ItemLinkData() (data struct{ Url, Title string }, err error)
 }
 
 func (props *Props) Scan(src any) error {
-	// Zero-out the receiver, Tags requires a special treatment because its zero value is nil, see
-	// https://go.dev/ref/spec#The_zero_value
-	*props = Props{Tags: Tags{}}
+	// Zero-out the receiver, Tags and All require a special treatment because their zero values are
+	// nil, see https://go.dev/ref/spec#The_zero_value
+	*props = Props{Tags: Tags{}, All: map[string]string{}}
 	val, ok := src.(string)
 	if !ok {
 		return fmt.Errorf("wrong source type, want string, got %v", reflect.TypeOf(src))
@@ -68,9 +71,11 @@ func (props *Props) Scan(src any) error {
 		"ICON":             &props.Icon,
 		"BROWSER_OVERRIDE": &props.BrowserOverride,
 		"NEW_WINDOW":       &props.NewWindow,
+		"KEYWORD":          &props.Keyword,
 	}
 	if matches := simplePropertyRe.FindAllStringSubmatch(val, -1); len(matches) > 0 {
 		for _, groups := range matches {
+			props.All[groups[1]] = groups[2]
 			if dest, found := matchDests[groups[1]]; found {
 				*dest = groups[2]
 			}
@@ -84,10 +89,11 @@ func (props *Props) Scan(src any) error {
 	return nil
 }
 
-var simplePropertyRe, tagsRe, linkRe *regexp.Regexp
+var simplePropertyRe, tagsRe, linkRe, olpRe *regexp.Regexp
 
 func init() {
 	simplePropertyRe = regexp.MustCompile(`"([^"]+)" \. "([^"]+)"`)
 	tagsRe = regexp.MustCompile(`"ALLTAGS" \. .{0,2}":([^"]+):"`)
 	linkRe = regexp.MustCompile(`\[\[([^\]]+)\]\[([^\]]+)\]\]`)
+	olpRe = regexp.MustCompile(`"((?:\\.|[^"])*)"`)
 }
@@ -0,0 +1,115 @@
+/*
+Copyright © 2023 Peter Solodov <solodov@gmail.com>
+*/
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Request is a single command invocation forwarded by a client.
+type Request struct {
+	Args []string `json:"args"`
+	Env  []string `json:"env"`
+	// Dir is the working directory of the client.
+	Dir string `json:"dir"`
+}
+
+type Response struct {
+	Stdout []byte `json:"stdout"`
+	Stderr []byte `json:"stderr"`
+	Code   int    `json:"code"`
+	// Busy means the request wasn't run because the server is busy with other requests.
+	Busy bool `json:"busy,omitempty"`
+}
+
+// ErrNotServed means the request was never run by the server, the client should run it in-process.
+var ErrNotServed = errors.New("request not served")
+
+const (
+	// dialTimeout bounds the time a client waits for a daemon that doesn't accept connections, it
+	// falls back to in-process execution afterwards.
+	dialTimeout = 100 * time.Millisecond
+	// callTimeout bounds the time a client waits for the response.
+	callTimeout = time.Minute
+	// ioTimeout bounds the time the server waits for a client to send the request or to read the
+	// response.
+	ioTimeout = 5 * time.Second
+)
+
+// Serve accepts connections on the unix socket at path and answers every request with handle. The
+// socket is only accessible to the user, requests run with the server's permissions. It returns
+// only on errors. Connections are handled concurrently, handle is expected to serialize
+// requests if necessary.
+func Serve(path string, handle func(Request) Response) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if conn, err := net.DialTimeout("unix", path, dialTimeout); err == nil {
+		conn.Close()
+		return fmt.Errorf("another server is already listening on %v", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	if err := os.Chmod(path, 0600); err != nil {
+		return err
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go serveConn(conn, handle)
+	}
+}
+
+func serveConn(conn net.Conn, handle func(Request) Response) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(ioTimeout))
+	var req Request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		log.Printf("invalid request: %v\n", err)
+		return
+	}
+	resp := handle(req)
+	conn.SetWriteDeadline(time.Now().Add(ioTimeout))
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		log.Printf("failed to send response: %v\n", err)
+	}
+}
+
+// Call forwards the request to the server listening at path. Errors wrapping ErrNotServed mean
+// the request wasn't run and should be run in-process, other errors mean it may have been run.
+func Call(path string, req Request) (*Response, error) {
+	conn, err := net.DialTimeout("unix", path, dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotServed, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(callTimeout))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotServed, err)
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Busy {
+		return nil, fmt.Errorf("%w: server is busy", ErrNotServed)
+	}
+	return &resp, nil
+}